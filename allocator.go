@@ -0,0 +1,94 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus.v2"
+)
+
+const allocatorPrefix = "allocator/mesos/"
+
+// Return the part of key between prefix and suffix, e.g. the role name of
+// "allocator/mesos/offer_filters/roles/<role>/active". Roles may contain
+// slashes, so the key is not simply split on "/".
+func keyInfix(key, prefix, suffix string) (string, bool) {
+	if len(key) < len(prefix)+len(suffix) || !strings.HasPrefix(key, prefix) || !strings.HasSuffix(key, suffix) {
+		return "", false
+	}
+	infix := key[len(prefix) : len(key)-len(suffix)]
+	if infix == "" {
+		return "", false
+	}
+	return infix, true
+}
+
+func newAllocatorCollector(httpClient *httpClient) prometheus.Collector {
+	metrics := map[prometheus.Collector]func(metricMap, prometheus.Collector) error{
+		// Allocator stats about allocation runs
+		counter("allocator", "allocation_runs_total", "Total number of allocation runs."): func(m metricMap, c prometheus.Collector) error {
+			return setCountersFound(m, c.(*settableCounterVec), map[string][]string{
+				allocatorPrefix + "allocation_runs": {},
+			})
+		},
+		gauge("allocator", "allocation_run_seconds", "Duration of recent allocation runs in seconds by quantile.", "quantile"): func(m metricMap, c prometheus.Collector) error {
+			return setTimerGauges(m, c.(*prometheus.GaugeVec), allocatorPrefix+"allocation_run_ms")
+		},
+		gauge("allocator", "allocation_run_latency_seconds", "Delay between recent allocation runs being triggered and their start in seconds by quantile.", "quantile"): func(m metricMap, c prometheus.Collector) error {
+			return setTimerGauges(m, c.(*prometheus.GaugeVec), allocatorPrefix+"allocation_run_latency_ms")
+		},
+		gauge("allocator", "event_queue_dispatches", "Current number of dispatch events in the allocator event queue."): func(m metricMap, c prometheus.Collector) error {
+			return setGaugesFound(m, c.(*prometheus.GaugeVec), map[string][]string{
+				allocatorPrefix + "event_queue_dispatches": {},
+			})
+		},
+
+		// Allocator stats per role
+		gauge("allocator", "offer_filters_active", "Current number of active offer filters by role.", "role"): func(m metricMap, c prometheus.Collector) error {
+			c.(*prometheus.GaugeVec).Reset()
+			for k, v := range m {
+				if role, ok := keyInfix(k, allocatorPrefix+"offer_filters/roles/", "/active"); ok {
+					c.(*prometheus.GaugeVec).WithLabelValues(role).Set(v)
+				}
+			}
+			return nil
+		},
+		gauge("allocator", "role_dominant_share", "Current dominant resource share by role.", "role"): func(m metricMap, c prometheus.Collector) error {
+			c.(*prometheus.GaugeVec).Reset()
+			for k, v := range m {
+				if role, ok := keyInfix(k, allocatorPrefix+"roles/", "/shares/dominant"); ok {
+					c.(*prometheus.GaugeVec).WithLabelValues(role).Set(v)
+				}
+			}
+			return nil
+		},
+		gauge("allocator", "quota_resources", "Current quota guarantee and offered or allocated quota resources by role.", "role", "resource", "type"): func(m metricMap, c prometheus.Collector) error {
+			c.(*prometheus.GaugeVec).Reset()
+			for k, v := range m {
+				for _, t := range []string{"guarantee", "offered_or_allocated"} {
+					infix, ok := keyInfix(k, allocatorPrefix+"quota/roles/", "/"+t)
+					if !ok {
+						continue
+					}
+					i := strings.LastIndex(infix, "/resources/")
+					if i == -1 {
+						continue
+					}
+					c.(*prometheus.GaugeVec).WithLabelValues(infix[:i], infix[i+len("/resources/"):], t).Set(v)
+				}
+			}
+			return nil
+		},
+		gauge("allocator", "resources", "Current total and offered or allocated resources known to the allocator.", "resource", "type"): func(m metricMap, c prometheus.Collector) error {
+			c.(*prometheus.GaugeVec).Reset()
+			for k, v := range m {
+				for _, t := range []string{"total", "offered_or_allocated"} {
+					if resource, ok := keyInfix(k, allocatorPrefix+"resources/", "/"+t); ok {
+						c.(*prometheus.GaugeVec).WithLabelValues(resource, t).Set(v)
+					}
+				}
+			}
+			return nil
+		},
+	}
+	return newMetricCollector(httpClient, metrics)
+}
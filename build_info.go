@@ -0,0 +1,136 @@
+// Scrape the /version and /flags endpoints of a master or agent to expose
+// which Mesos build is running and how it is configured:
+//
+// * Build version, git sha and build date ("mesos_build_info" series)
+// * Whitelisted flag values ("mesos_flags_info" series)
+// * Hash over all flag values ("mesos_flags_hash" series)
+package main
+
+import (
+	"hash/fnv"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus.v2"
+)
+
+type (
+	versionInfo struct {
+		Version   string `json:"version"`
+		GitSHA    string `json:"git_sha"`
+		GitTag    string `json:"git_tag"`
+		BuildDate string `json:"build_date"`
+	}
+
+	flagsInfo struct {
+		Flags map[string]string `json:"flags"`
+	}
+
+	buildInfo struct {
+		version versionInfo
+		flags   flagsInfo
+	}
+
+	buildInfoCollector struct {
+		*httpClient
+		metrics map[prometheus.Collector]func(*buildInfo, prometheus.Collector)
+	}
+)
+
+// Flags that legitimately differ between otherwise identical nodes and
+// would make mesos_flags_hash useless for drift detection.
+var defaultFlagsHashExclude = []string{"hostname", "ip", "advertise_ip", "advertise_port", "port", "master"}
+
+// Hash the flags as sorted key=value pairs. FNV-32a is used so the value
+// survives the conversion to float64 without losing precision.
+func flagsHash(flags map[string]string, exclude []string) uint32 {
+	keys := []string{}
+	for k := range flags {
+		if inArray(k, exclude) {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := fnv.New32a()
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{'='})
+		h.Write([]byte(flags[k]))
+		h.Write([]byte{0})
+	}
+	return h.Sum32()
+}
+
+func newBuildInfoCollector(httpClient *httpClient, exportedFlags []string, hashExclude []string) prometheus.Collector {
+	// Sanitise user-supplied list of flags that should be included in the series
+	normalisedFlagList := []string{}
+	for _, flag := range exportedFlags {
+		normalisedFlagList = append(normalisedFlagList, normaliseLabel(flag))
+	}
+
+	metrics := map[prometheus.Collector]func(*buildInfo, prometheus.Collector){
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "Mesos build information, always 1",
+			Namespace: "mesos",
+			Name:      "build_info",
+		}, []string{"version", "git_sha", "git_tag", "build_date"}): func(bi *buildInfo, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			c.(*prometheus.GaugeVec).WithLabelValues(
+				bi.version.Version,
+				bi.version.GitSHA,
+				bi.version.GitTag,
+				bi.version.BuildDate,
+			).Set(1)
+		},
+		prometheus.NewGauge(prometheus.GaugeOpts{
+			Help:      "FNV-32a hash over all flag values, excluding node specific flags",
+			Namespace: "mesos",
+			Name:      "flags_hash",
+		}): func(bi *buildInfo, c prometheus.Collector) {
+			c.(prometheus.Gauge).Set(float64(flagsHash(bi.flags.Flags, hashExclude)))
+		},
+	}
+
+	if len(normalisedFlagList) > 0 {
+		metrics[prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "Values of whitelisted flags, always 1",
+			Namespace: "mesos",
+			Name:      "flags_info",
+		}, normalisedFlagList)] = func(bi *buildInfo, c prometheus.Collector) {
+			flagLabels := map[string]string{}
+			for _, flag := range normalisedFlagList {
+				flagLabels[flag] = ""
+			}
+			for k, v := range bi.flags.Flags {
+				normalisedFlag := normaliseLabel(k)
+				// Ignore flags not explicitly whitelisted by user
+				if inArray(normalisedFlag, normalisedFlagList) {
+					flagLabels[normalisedFlag] = v
+				}
+			}
+			c.(*prometheus.GaugeVec).Reset()
+			c.(*prometheus.GaugeVec).With(flagLabels).Set(1)
+		}
+	}
+
+	return &buildInfoCollector{httpClient, metrics}
+}
+
+func (c *buildInfoCollector) Collect(ch chan<- prometheus.Metric) {
+	var bi buildInfo
+	// Don't export empty info series when the node is unreachable
+	if !c.fetchAndDecode("/version", &bi.version) || !c.fetchAndDecode("/flags", &bi.flags) {
+		return
+	}
+	for c, set := range c.metrics {
+		set(&bi, c)
+		c.Collect(ch)
+	}
+}
+
+func (c *buildInfoCollector) Describe(ch chan<- *prometheus.Desc) {
+	for metric := range c.metrics {
+		metric.Describe(ch)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus.v2"
+)
+
+type taskShape struct {
+	name      string
+	resources resources
+}
+
+// Histogram buckets for the free capacity per slave (cpus fractional, mem
+// and disk in MB).
+var freeCapacityBuckets = map[string][]float64{
+	"cpus": {0.5, 1, 2, 4, 8, 16, 32},
+	"mem":  {512, 1024, 2048, 4096, 8192, 16384, 32768, 65536},
+	"disk": {1024, 4096, 16384, 65536, 262144, 1048576},
+	"gpus": {1, 2, 4, 8},
+}
+
+// Parse a comma-separated list of task shapes in the form
+// name=cpus:mem[:disk[:gpus]], e.g. "small=0.5:512,large=4:16384:10240:1".
+func parseTaskShapes(s string) ([]taskShape, error) {
+	shapes := []taskShape{}
+	if s == "" {
+		return shapes, nil
+	}
+	for _, spec := range strings.Split(s, ",") {
+		parts := strings.SplitN(spec, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("bad task shape: %s", spec)
+		}
+		values := strings.Split(parts[1], ":")
+		if len(values) < 2 || len(values) > 4 {
+			return nil, fmt.Errorf("bad task shape: %s", spec)
+		}
+		var amounts [4]float64
+		for i, v := range values {
+			amount, err := strconv.ParseFloat(v, 64)
+			if err != nil || amount < 0 {
+				return nil, fmt.Errorf("bad task shape: %s", spec)
+			}
+			amounts[i] = amount
+		}
+		shapes = append(shapes, taskShape{
+			name:      parts[0],
+			resources: resources{CPUs: amounts[0], Mem: amounts[1], Disk: amounts[2], GPUs: amounts[3]},
+		})
+	}
+	return shapes, nil
+}
+
+// Return the unreserved resources of the slave which are neither used by
+// tasks nor offered to frameworks, i.e. what a task without a role can get.
+func (s *slave) free() resources {
+	free := s.Unreserved
+	for _, rs := range [][]resourceFull{s.UsedFull, s.OfferedFull} {
+		for _, r := range rs {
+			if r.Type != "SCALAR" || r.reserved() {
+				continue
+			}
+			switch r.Name {
+			case "cpus":
+				free.CPUs -= r.Scalar.Value
+			case "mem":
+				free.Mem -= r.Scalar.Value
+			case "disk":
+				free.Disk -= r.Scalar.Value
+			case "gpus":
+				free.GPUs -= r.Scalar.Value
+			}
+		}
+	}
+	return free
+}
+
+// Return whether new tasks can be placed on the slave: it is connected and
+// neither deactivated nor draining.
+func (s *slave) schedulable() bool {
+	return s.Active && !s.Deactivated && s.DrainInfo == nil
+}
+
+// Return the slaves new tasks can be placed on.
+func schedulableSlaves(st *state) []*slave {
+	slaves := []*slave{}
+	for i := range st.Slaves {
+		if st.Slaves[i].schedulable() {
+			slaves = append(slaves, &st.Slaves[i])
+		}
+	}
+	return slaves
+}
+
+// Return how many instances of shape fit into free.
+func (shape *taskShape) fits(free resources) int {
+	n := math.MaxInt32
+	for _, r := range [][2]float64{
+		{free.CPUs, shape.resources.CPUs},
+		{free.Mem, shape.resources.Mem},
+		{free.Disk, shape.resources.Disk},
+		{free.GPUs, shape.resources.GPUs},
+	} {
+		if r[1] <= 0 {
+			continue
+		}
+		if fit := int(math.Floor(r[0] / r[1])); fit < n {
+			n = fit
+		}
+	}
+	if n == math.MaxInt32 || n < 0 {
+		return 0
+	}
+	return n
+}
+
+func freeAmounts(r resources) map[string]float64 {
+	return map[string]float64{"cpus": r.CPUs, "mem": r.Mem, "disk": r.Disk, "gpus": r.GPUs}
+}
+
+type freeCapacityHistogram struct {
+	desc   *prometheus.Desc
+	values []prometheus.Metric
+}
+
+func (h *freeCapacityHistogram) Describe(ch chan<- *prometheus.Desc) {
+	ch <- h.desc
+}
+
+func (h *freeCapacityHistogram) Collect(ch chan<- prometheus.Metric) {
+	for _, v := range h.values {
+		ch <- v
+	}
+	h.values = nil
+}
+
+// Cluster capacity metrics derived from the free resources of the slaves
+// new tasks can be placed on, which the per slave gauges don't show: the
+// largest free slot, the distribution of free capacity and how many
+// instances of the configured task shapes fit.
+func capacityMetrics(shapes []taskShape) map[prometheus.Collector]func(*state, prometheus.Collector) {
+	return map[prometheus.Collector]func(*state, prometheus.Collector){
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "Largest amount of a free unreserved resource on a single schedulable slave (cpus fractional, mem and disk in MB)",
+			Namespace: "mesos",
+			Subsystem: "cluster",
+			Name:      "largest_free_slot",
+		}, []string{"resource"}): func(st *state, c prometheus.Collector) {
+			largest := map[string]float64{"cpus": 0, "mem": 0, "disk": 0, "gpus": 0}
+			for _, s := range schedulableSlaves(st) {
+				for r, amount := range freeAmounts(s.free()) {
+					if amount > largest[r] {
+						largest[r] = amount
+					}
+				}
+			}
+			for r, amount := range largest {
+				c.(*prometheus.GaugeVec).WithLabelValues(r).Set(amount)
+			}
+		},
+		&freeCapacityHistogram{
+			desc: prometheus.NewDesc(
+				prometheus.BuildFQName("mesos", "cluster", "slave_free_resources"),
+				"Distribution of free unreserved resources per schedulable slave (cpus fractional, mem and disk in MB)",
+				[]string{"resource"}, nil,
+			),
+		}: func(st *state, c prometheus.Collector) {
+			h := c.(*freeCapacityHistogram)
+			slaves := schedulableSlaves(st)
+			for r, buckets := range freeCapacityBuckets {
+				counts := map[float64]uint64{}
+				sum := 0.0
+				for _, s := range slaves {
+					amount := freeAmounts(s.free())[r]
+					sum += amount
+					for _, b := range buckets {
+						if amount <= b {
+							counts[b]++
+						}
+					}
+				}
+				h.values = append(h.values, prometheus.MustNewConstHistogram(h.desc, uint64(len(slaves)), sum, counts, r))
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "Number of instances of the task shape which fit into the free unreserved resources of all schedulable slaves",
+			Namespace: "mesos",
+			Subsystem: "cluster",
+			Name:      "task_shape_fits",
+		}, []string{"shape"}): func(st *state, c prometheus.Collector) {
+			for _, shape := range shapes {
+				fits := 0
+				for _, s := range schedulableSlaves(st) {
+					fits += shape.fits(s.free())
+				}
+				c.(*prometheus.GaugeVec).WithLabelValues(shape.name).Set(float64(fits))
+			}
+		},
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"log"
+	"math"
 	"net/http"
 	"strings"
 
@@ -15,6 +17,7 @@ type (
 		CPUs  float64 `json:"cpus"`
 		Disk  float64 `json:"disk"`
 		Mem   float64 `json:"mem"`
+		GPUs  float64 `json:"gpus"`
 		Ports ranges  `json:"ports"`
 	}
 
@@ -36,8 +39,23 @@ type (
 	}
 
 	status struct {
-		State     string  `json:"state"`
-		Timestamp float64 `json:"timestamp"`
+		State       string       `json:"state"`
+		Timestamp   float64      `json:"timestamp"`
+		Healthy     *bool        `json:"healthy"`
+		CheckStatus *checkStatus `json:"check_status"`
+	}
+
+	checkStatus struct {
+		Type    string `json:"type"`
+		Command *struct {
+			ExitCode *int `json:"exit_code"`
+		} `json:"command"`
+		HTTP *struct {
+			StatusCode *int `json:"status_code"`
+		} `json:"http"`
+		TCP *struct {
+			Succeeded *bool `json:"succeeded"`
+		} `json:"tcp"`
 	}
 )
 
@@ -45,6 +63,9 @@ type metricMap map[string]float64
 
 var (
 	notFoundInMap = errors.New("Couldn't find key in map")
+	// Returned for optional keys which older Mesos versions don't publish.
+	// The metric is skipped without being counted as a collector error.
+	notPublishedInMap = errors.New("Key not published by this Mesos version")
 )
 
 type settableCounterVec struct {
@@ -91,6 +112,34 @@ func (c *settableCounter) Set(value float64) {
 	c.value = prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, value)
 }
 
+// Quantiles of the percentiles Mesos publishes for its timers, by key suffix
+var timerQuantiles = map[string]string{
+	"p50":   "0.5",
+	"p90":   "0.9",
+	"p95":   "0.95",
+	"p99":   "0.99",
+	"p999":  "0.999",
+	"p9999": "0.9999",
+}
+
+// Set the percentiles of a Mesos timer (e.g. "allocator/mesos/allocation_run_ms")
+// by quantile, converted from milliseconds to seconds. The timer's count is
+// the size of its sliding window rather than a total, and there is no sum,
+// so it can't be exported as a summary.
+func setTimerGauges(m metricMap, g *prometheus.GaugeVec, key string) error {
+	found := false
+	for suffix, q := range timerQuantiles {
+		if v, ok := m[key+"/"+suffix]; ok {
+			g.WithLabelValues(q).Set(v / 1000)
+			found = true
+		}
+	}
+	if !found {
+		return notPublishedInMap
+	}
+	return nil
+}
+
 func newSettableCounter(subsystem, name, help string) *settableCounter {
 	return &settableCounter{
 		desc: prometheus.NewDesc(
@@ -125,6 +174,65 @@ func counter(subsystem, name, help string, labels ...string) *settableCounterVec
 	}
 }
 
+// Set one series per key found in the snapshot, labelled with the key's
+// value in keys. Returns notPublishedInMap if none of the keys are present.
+func setGaugesFound(m metricMap, g *prometheus.GaugeVec, keys map[string][]string) error {
+	found := false
+	for key, labelValues := range keys {
+		v, ok := m[key]
+		if !ok {
+			continue
+		}
+		g.WithLabelValues(labelValues...).Set(v)
+		found = true
+	}
+	if !found {
+		return notPublishedInMap
+	}
+	return nil
+}
+
+// Same as setGaugesFound for settable counters.
+func setCountersFound(m metricMap, c *settableCounterVec, keys map[string][]string) error {
+	found := false
+	for key, labelValues := range keys {
+		v, ok := m[key]
+		if !ok {
+			continue
+		}
+		c.Set(v, labelValues...)
+		found = true
+	}
+	if !found {
+		return notPublishedInMap
+	}
+	return nil
+}
+
+// Host metrics published by both master and agent under system/*.
+func systemMetrics(subsystem string) map[prometheus.Collector]func(metricMap, prometheus.Collector) error {
+	return map[prometheus.Collector]func(metricMap, prometheus.Collector) error{
+		gauge(subsystem, "system_load", "Host load average by window.", "window"): func(m metricMap, c prometheus.Collector) error {
+			return setGaugesFound(m, c.(*prometheus.GaugeVec), map[string][]string{
+				"system/load_1min":  {"1min"},
+				"system/load_5min":  {"5min"},
+				"system/load_15min": {"15min"},
+			})
+		},
+		gauge(subsystem, "system_cpus", "Number of CPUs available on the host."): func(m metricMap, c prometheus.Collector) error {
+			return setGaugesFound(m, c.(*prometheus.GaugeVec), map[string][]string{
+				"system/cpus_total": {},
+			})
+		},
+		gauge(subsystem, "system_mem_bytes", "Host memory in bytes.", "type"): func(m metricMap, c prometheus.Collector) error {
+			return setGaugesFound(m, c.(*prometheus.GaugeVec), map[string][]string{
+				"system/mem_total_bytes": {"total"},
+				"system/mem_free_bytes":  {"free"},
+			})
+		},
+	}
+}
+
 type authInfo struct {
 	username string
 	password string
@@ -152,6 +260,30 @@ func (httpClient *httpClient) fetchAndDecode(endpoint string, target interface{}
 		log.Printf("Error creating HTTP request to %s: %s", url, err)
 		return false
 	}
+	return httpClient.doAndDecode(req, target)
+}
+
+// POST a JSON encoded call, e.g. to the v1 operator API, and decode the
+// JSON response.
+func (httpClient *httpClient) postAndDecode(endpoint string, call interface{}, target interface{}) bool {
+	url := strings.TrimSuffix(httpClient.url, "/") + endpoint
+	body, err := json.Marshal(call)
+	if err != nil {
+		log.Printf("Error encoding HTTP request to %s: %s", url, err)
+		return false
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Error creating HTTP request to %s: %s", url, err)
+		return false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	return httpClient.doAndDecode(req, target)
+}
+
+func (httpClient *httpClient) doAndDecode(req *http.Request, target interface{}) bool {
+	url := req.URL.String()
 	if httpClient.auth.username != "" && httpClient.auth.password != "" {
 		req.SetBasicAuth(httpClient.auth.username, httpClient.auth.password)
 	}
@@ -177,6 +309,9 @@ func (c *metricCollector) Collect(ch chan<- prometheus.Metric) {
 	c.fetchAndDecode("/metrics/snapshot", &m)
 	for cm, f := range c.metrics {
 		if err := f(m, cm); err != nil {
+			if err == notPublishedInMap {
+				continue
+			}
 			if err == notFoundInMap {
 				ch := make(chan *prometheus.Desc, 1)
 				cm.Describe(ch)
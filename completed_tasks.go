@@ -0,0 +1,140 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus.v2"
+)
+
+// Return the timestamp of the task's latest status update, 0 if it has none.
+func (t *task) lastTimestamp() float64 {
+	if len(t.Statuses) == 0 {
+		return 0
+	}
+	return t.Statuses[len(t.Statuses)-1].Timestamp
+}
+
+type (
+	frameworkTask struct {
+		framework *framework
+		task      *task
+	}
+
+	// Identifies a completed task across scrapes. Task IDs may be reused,
+	// the time of the final status update tells the runs apart.
+	completedKey struct {
+		id        string
+		timestamp float64
+	}
+)
+
+// Return the completed tasks which weren't seen in the previous scrape,
+// given the tasks seen then, along with the tasks seen in this one. The
+// master only retains a limited number of completed tasks, so only the
+// tasks of the previous scrape need to be remembered.
+func newlyCompleted(st *state, counted map[completedKey]bool) ([]frameworkTask, map[completedKey]bool) {
+	tasks := []frameworkTask{}
+	seen := map[completedKey]bool{}
+	for i := range st.Frameworks {
+		f := &st.Frameworks[i]
+		for j := range f.Completed {
+			t := &f.Completed[j]
+			key := completedKey{t.ID, t.lastTimestamp()}
+			seen[key] = true
+			if !counted[key] {
+				tasks = append(tasks, frameworkTask{f, t})
+			}
+		}
+	}
+	return tasks, seen
+}
+
+// Metrics about the completed tasks the master retains, aggregated by
+// framework and task name so the number of series doesn't grow with every
+// task launched. seriesLimit > 0 additionally exports up to seriesLimit of
+// the most recently completed tasks by task ID for debugging.
+func completedTaskMetrics(seriesLimit int) map[prometheus.Collector]func(*state, prometheus.Collector) {
+	// Completed tasks already added to the counter
+	counted := map[completedKey]bool{}
+
+	metrics := map[prometheus.Collector]func(*state, prometheus.Collector){
+		prometheus.NewCounterVec(prometheus.CounterOpts{
+			Help:      "Total number of completed tasks by framework, task name and terminal state",
+			Namespace: "mesos",
+			Subsystem: "framework",
+			Name:      "completed_tasks_total",
+		}, []string{"framework_id", "framework", "name", "state"}): func(st *state, c prometheus.Collector) {
+			// Without a state every retained task would count again next time
+			if !st.fetched {
+				return
+			}
+			var tasks []frameworkTask
+			tasks, counted = newlyCompleted(st, counted)
+			for _, ft := range tasks {
+				c.(*prometheus.CounterVec).WithLabelValues(ft.framework.ID, ft.framework.Name, ft.task.Name, ft.task.State).Inc()
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "Time the last task completed in seconds since epoch by framework and task name",
+			Namespace: "mesos",
+			Subsystem: "framework",
+			Name:      "task_last_completion_timestamp_seconds",
+		}, []string{"framework_id", "framework", "name"}): func(st *state, c prometheus.Collector) {
+			for _, f := range st.Frameworks {
+				last := map[string]float64{}
+				for _, t := range f.Completed {
+					if ts := t.lastTimestamp(); ts > last[t.Name] {
+						last[t.Name] = ts
+					}
+				}
+				// Keep the previous value for apps whose completed tasks
+				// were dropped by the master in the meantime
+				for name, ts := range last {
+					c.(*prometheus.GaugeVec).WithLabelValues(f.ID, f.Name, name).Set(ts)
+				}
+			}
+		},
+	}
+
+	if seriesLimit > 0 {
+		metrics[prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "Completed framework tasks, limited to the most recently completed ones",
+			Namespace: "mesos",
+			Subsystem: "slave",
+			Name:      "task_state_time",
+		}, []string{"slave", "task", "executor", "name", "framework", "state"})] = func(st *state, c prometheus.Collector) {
+			completed := []task{}
+			for _, f := range st.Frameworks {
+				if !f.Active {
+					continue
+				}
+				for _, t := range f.Completed {
+					if len(t.Statuses) > 0 {
+						completed = append(completed, t)
+					}
+				}
+			}
+			sort.SliceStable(completed, func(i, j int) bool {
+				return completed[i].lastTimestamp() > completed[j].lastTimestamp()
+			})
+			if len(completed) > seriesLimit {
+				completed = completed[:seriesLimit]
+			}
+
+			c.(*prometheus.GaugeVec).Reset()
+			for _, task := range completed {
+				values := []string{
+					task.SlaveID,
+					task.ID,
+					task.ExecutorID,
+					task.Name,
+					task.FrameworkID,
+					task.State,
+				}
+				c.(*prometheus.GaugeVec).WithLabelValues(values...).Set(task.Statuses[0].Timestamp)
+			}
+		}
+	}
+
+	return metrics
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus.v2"
+)
+
+// Terminal task states counted as a failure. TASK_KILLED is left out as
+// schedulers kill tasks on purpose, e.g. during deployments.
+var failedTaskStates = []string{"TASK_FAILED", "TASK_ERROR", "TASK_LOST", "TASK_DROPPED"}
+
+type (
+	appKey struct {
+		frameworkID string
+		app         string
+	}
+
+	// Tracks terminal task failures per app across scrapes. Failures are
+	// taken from the completed tasks the master retains, so tasks which fail
+	// and get relaunched between two scrapes are still counted.
+	crashLoopDetector struct {
+		appLabel  string
+		window    time.Duration
+		threshold int
+
+		counted  map[completedKey]bool
+		failures map[appKey][]float64
+		restarts *prometheus.CounterVec
+	}
+)
+
+func newCrashLoopDetector(appLabel string, window time.Duration, threshold int) *crashLoopDetector {
+	return &crashLoopDetector{
+		appLabel:  appLabel,
+		window:    window,
+		threshold: threshold,
+		counted:   map[completedKey]bool{},
+		failures:  map[appKey][]float64{},
+		restarts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Help:      "Total number of failed tasks by framework and app",
+			Namespace: "mesos",
+			Subsystem: "task",
+			Name:      "restarts_total",
+		}, []string{"framework_id", "app"}),
+	}
+}
+
+// Return the app a task belongs to: the value of the configured task label,
+// falling back to the task name.
+func (d *crashLoopDetector) app(t *task) string {
+	if d.appLabel != "" {
+		for _, l := range t.Labels {
+			if l.Key == d.appLabel {
+				return l.Value
+			}
+		}
+	}
+	return t.Name
+}
+
+// Count failures which weren't seen in a previous scrape and drop the ones
+// which fell out of the window. Calling it again with the same state changes
+// nothing, so every metric can call it.
+func (d *crashLoopDetector) update(st *state) {
+	now := float64(time.Now().UnixNano()) / 1e9
+	// Without a state every retained failure would count again next time
+	if st.fetched {
+		d.count(st, now)
+	}
+
+	since := now - d.window.Seconds()
+	for key, timestamps := range d.failures {
+		recent := timestamps[:0]
+		for _, ts := range timestamps {
+			if ts >= since {
+				recent = append(recent, ts)
+			}
+		}
+		if len(recent) == 0 {
+			delete(d.failures, key)
+			continue
+		}
+		d.failures[key] = recent
+	}
+}
+
+// Count the failures which weren't seen in the previous scrape.
+func (d *crashLoopDetector) count(st *state, now float64) {
+	var tasks []frameworkTask
+	tasks, d.counted = newlyCompleted(st, d.counted)
+	for _, ft := range tasks {
+		if !inArray(ft.task.State, failedTaskStates) {
+			continue
+		}
+		key := appKey{ft.framework.ID, d.app(ft.task)}
+		failedAt := ft.task.lastTimestamp()
+		if failedAt == 0 {
+			failedAt = now
+		}
+		d.failures[key] = append(d.failures[key], failedAt)
+		d.restarts.WithLabelValues(key.frameworkID, key.app).Inc()
+	}
+}
+
+func (d *crashLoopDetector) metrics() map[prometheus.Collector]func(*state, prometheus.Collector) {
+	return map[prometheus.Collector]func(*state, prometheus.Collector){
+		d.restarts: func(st *state, c prometheus.Collector) {
+			d.update(st)
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "Number of failed tasks within the crash loop window by framework and app",
+			Namespace: "mesos",
+			Subsystem: "task",
+			Name:      "recent_failures",
+		}, []string{"framework_id", "app"}): func(st *state, c prometheus.Collector) {
+			d.update(st)
+			c.(*prometheus.GaugeVec).Reset()
+			for key, timestamps := range d.failures {
+				c.(*prometheus.GaugeVec).WithLabelValues(key.frameworkID, key.app).Set(float64(len(timestamps)))
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "1 if the app failed at least the crash loop threshold times within the window, 0 if not",
+			Namespace: "mesos",
+			Subsystem: "task",
+			Name:      "crashlooping",
+		}, []string{"framework_id", "app"}): func(st *state, c prometheus.Collector) {
+			d.update(st)
+			c.(*prometheus.GaugeVec).Reset()
+			for key, timestamps := range d.failures {
+				crashlooping := 0.0
+				if len(timestamps) >= d.threshold {
+					crashlooping = 1
+				}
+				c.(*prometheus.GaugeVec).WithLabelValues(key.frameworkID, key.app).Set(crashlooping)
+			}
+		},
+	}
+}
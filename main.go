@@ -8,6 +8,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"regexp"
 	"strings"
 	"time"
 
@@ -31,20 +32,65 @@ func getX509CertPool(pemFiles []string) *x509.CertPool {
 		}
 		ok := pool.AppendCertsFromPEM(content)
 		if !ok {
-			log.Fatal("Error parsing .pem file %s", f)
+			log.Fatalf("Error parsing .pem file %s", f)
 		}
 	}
 	return pool
 }
-func mkConsulHttpClient(url string, timeout time.Duration, auth consul_server.AuthInfo, certPool *x509.CertPool) *consul_server.HttpClient {
+
+// Compile a regex given on the command line, nil if it is empty.
+func compileRegexp(expr string) *regexp.Regexp {
+	if expr == "" {
+		return nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return re
+}
+
+// TLS configuration for Consul. The CA file is trusted in addition to the
+// trusted certificates, in a pool of its own so the Mesos clients don't
+// trust it too. The client certificate is presented to servers verifying
+// incoming connections.
+func mkConsulTLSConfig(trustedCerts []string, caFile, certFile, keyFile, serverName string) *tls.Config {
+	config := &tls.Config{ServerName: serverName}
+	if caFile != "" {
+		trustedCerts = append(trustedCerts, caFile)
+	}
+	if len(trustedCerts) > 0 {
+		config.RootCAs = getX509CertPool(trustedCerts)
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config
+}
+
+func mkConsulHttpClient(url string, timeout time.Duration, auth consul_server.AuthInfo, query consul_server.QueryConfig, tlsConfig *tls.Config) *consul_server.HttpClient {
 	transport := &http.Transport{
-		TLSClientConfig: &tls.Config{RootCAs: certPool},
+		TLSClientConfig: tlsConfig,
 	}
 	return &consul_server.HttpClient{
 		http.Client{Timeout: timeout, Transport: transport},
 		url,
 		auth,
+		query,
+	}
+}
+
+// Read an ACL token from a file, ignoring surrounding whitespace.
+func readToken(path string) string {
+	token, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatalf("cannot read token file %s: %s", path, err)
 	}
+	return strings.TrimSpace(string(token))
 }
 
 func mkHttpClient(url string, timeout time.Duration, auth authInfo, certPool *x509.CertPool) *httpClient {
@@ -63,11 +109,42 @@ func main() {
 	addr := fs.String("addr", ":9111", "Address to listen on")
 	masterURL := fs.String("master", "", "Expose metrics from master running on this URL")
 	consulServer := fs.String("consulServer", "", "Expose metrics from consulServer")
+	consulUsername := fs.String("consulUsername", "", "Username for HTTP basic auth against Consul (default: user of $CONSUL_HTTP_AUTH)")
+	consulPassword := fs.String("consulPassword", "", "Password for HTTP basic auth against Consul (default: password of $CONSUL_HTTP_AUTH)")
+	consulTokenFile := fs.String("consulTokenFile", "", "File containing the Consul ACL token (default: $CONSUL_HTTP_TOKEN)")
+	consulDatacenter := fs.String("consulDatacenter", "", "Consul datacenter to query (default: datacenter of the agent)")
+	consulCAFile := fs.String("consulCAFile", "", "CA certificate (.pem file) trusted for requests to Consul in addition to -trustedCerts")
+	consulClientCert := fs.String("consulClientCert", "", "Client certificate (.pem file) presented to Consul servers")
+	consulClientKey := fs.String("consulClientKey", "", "Private key (.pem file) of the Consul client certificate")
+	consulServerName := fs.String("consulServerName", "", "Server name expected in the certificate of the Consul server (default: host of -consulServer)")
+	consulDatacenters := fs.String("consulDatacenters", "", "Comma-separated list of Consul datacenters to scrape catalog, health and Raft state of, or * for all (default: -consulDatacenter)")
+	consulStale := fs.Bool("consulStale", false, "Allow any Consul server to answer queries, not only the leader")
+	consulConsistent := fs.Bool("consulConsistent", false, "Require fully consistent Consul queries")
+	consulServiceTags := fs.String("consulServiceTags", "", "Comma-separated list of Consul service tags to include in the service_info metric")
+	consulServiceWatchLimit := fs.Int("consulServiceWatchLimit", 100, "Maximum number of Consul services, across datacenters, to watch the instances of for per-service catalog metrics")
+	consulRTTTopN := fs.Int("consulRTTTopN", 10, "Export the estimated round trip time of the N Consul nodes farthest from the servers")
+	consulKVPrefixes := fs.String("consulKVPrefixes", "", "Comma-separated list of Consul KV prefixes to export key metrics for")
+	consulKVValuePattern := fs.String("consulKVValuePattern", "", "Regex of Consul KV keys whose numeric values are exported")
+	consulHealthServiceInclude := fs.String("consulHealthServiceInclude", "", "Regex of Consul service names to export health metrics for")
+	consulHealthServiceExclude := fs.String("consulHealthServiceExclude", "", "Regex of Consul service names to leave out of health metrics")
+	consulHealthCheckInclude := fs.String("consulHealthCheckInclude", "", "Regex of Consul check IDs to export check status for")
+	consulHealthCheckExclude := fs.String("consulHealthCheckExclude", "", "Regex of Consul check IDs to leave out of check status")
 	slaveURL := fs.String("agent", "", "Expose metrics from slave running on this URL")
 	timeout := fs.Duration("timeout", 5*time.Second, "Master polling timeout")
 	exportedTaskLabels := fs.String("exportedTaskLabels", "", "Comma-separated list of task labels to include in the task_labels metric")
-	ignoreCompletedFrameworkTasks := fs.Bool("ignoreCompletedFrameworkTasks", false, "Don't export task_state_time metric")
+	ignoreCompletedFrameworkTasks := fs.Bool("ignoreCompletedFrameworkTasks", false, "Don't export completed task metrics")
+	completedTaskSeriesLimit := fs.Int("completedTaskSeriesLimit", 0, "Debug: export the task_state_time metric for up to this many of the most recently completed tasks (0 disables)")
 	trustedCerts := fs.String("trustedCerts", "", "Comma-separated list of certificates (.pem files) trusted for requests to Mesos endpoints")
+	exportedFlags := fs.String("exportedFlags", "isolation,containerizers,resources,attributes", "Comma-separated list of master/agent flags to include in the flags_info metric")
+	crashLoopAppLabel := fs.String("crashLoopAppLabel", "", "Task label identifying the app of a task for crash loop detection (default: task name)")
+	crashLoopWindow := fs.Duration("crashLoopWindow", 10*time.Minute, "Sliding window in which task failures are counted for crash loop detection")
+	crashLoopThreshold := fs.Int("crashLoopThreshold", 3, "Number of task failures within the window after which an app is considered crashlooping")
+	taskShapes := fs.String("taskShapes", "", "Comma-separated list of task shapes name=cpus:mem[:disk[:gpus]] to export the task_shape_fits metric for")
+	slaveResources := fs.Bool("slaveResources", false, "Expose reserved resources and persistent volumes of the agent")
+	resourceProviders := fs.Bool("resourceProviders", false, "Expose resource provider and CSI plugin metrics from the agent (Mesos 1.5+)")
+	overlay := fs.Bool("overlay", false, "Expose metrics from the DC/OS overlay network module")
+	processQueueTopN := fs.Int("processQueueTopN", 0, "Export event queue lengths of the N libprocess actors with the longest queues (0 disables)")
+	flagsHashExclude := fs.String("flagsHashExclude", strings.Join(defaultFlagsHashExclude, ","), "Comma-separated list of node specific flags left out of the flags_hash metric")
 
 	fs.Parse(os.Args[1:])
 
@@ -76,9 +153,22 @@ func main() {
 		os.Getenv("MESOS_EXPORTER_PASSWORD"),
 	}
 
+	// Consul has its own credentials, the Consul API falls back to
+	// $CONSUL_HTTP_AUTH without them
 	Auth := consul_server.AuthInfo{
-		os.Getenv("MESOS_EXPORTER_USERNAME"),
-		os.Getenv("MESOS_EXPORTER_PASSWORD"),
+		*consulUsername,
+		*consulPassword,
+	}
+
+	var flagList []string
+	if *exportedFlags != "" {
+		flagList = strings.Split(*exportedFlags, ",")
+	}
+	hashExclude := strings.Split(*flagsHashExclude, ",")
+
+	shapes, err := parseTaskShapes(*taskShapes)
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	var certPool *x509.CertPool = nil
@@ -87,20 +177,89 @@ func main() {
 	}
 
 	if *consulServer != "" {
+		if *consulStale && *consulConsistent {
+			log.Fatal("-consulStale and -consulConsistent are mutually exclusive")
+		}
+		query := consul_server.QueryConfig{
+			Datacenter:        *consulDatacenter,
+			AllowStale:        *consulStale,
+			RequireConsistent: *consulConsistent,
+		}
+		if *consulTokenFile != "" {
+			query.Token = readToken(*consulTokenFile)
+		}
+		if *consulDatacenters != "" {
+			query.Datacenters = strings.Split(*consulDatacenters, ",")
+		}
+		var trusted []string
+		if *trustedCerts != "" {
+			trusted = strings.Split(*trustedCerts, ",")
+		}
+		tlsConfig := mkConsulTLSConfig(trusted, *consulCAFile, *consulClientCert, *consulClientKey, *consulServerName)
+		var serviceTags []string
+		if *consulServiceTags != "" {
+			serviceTags = strings.Split(*consulServiceTags, ",")
+		}
+		healthFilter := consul_server.HealthFilter{
+			ServiceInclude: compileRegexp(*consulHealthServiceInclude),
+			ServiceExclude: compileRegexp(*consulHealthServiceExclude),
+			CheckInclude:   compileRegexp(*consulHealthCheckInclude),
+			CheckExclude:   compileRegexp(*consulHealthCheckExclude),
+		}
+
 		reg := prometheus.NewCustomRegistry()
 		if _, err := reg.Register(errorCounter); err != nil {
 			log.Fatal(err)
 		}
-		for _, f := range []func(*consul_server.HttpClient) prometheus.Collector{
+		// Catalog, health and KV data is kept up to date by blocking
+		// queries and shared by the collectors
+		views, err := consul_server.NewViews(*consulServer, mkConsulHttpClient(*consulServer, *timeout, Auth, query, tlsConfig))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if _, err := reg.Register(views); err != nil {
+			log.Fatal(err)
+		}
+		if _, err := reg.Register(consul_server.QueryErrors); err != nil {
+			log.Fatal(err)
+		}
+		consulCollectors := []func(*consul_server.HttpClient) prometheus.Collector{
+			func(*consul_server.HttpClient) prometheus.Collector {
+				return consul_server.NewConsulServerCollector(views, serviceTags, *consulServiceWatchLimit)
+			},
+			func(c *consul_server.HttpClient) prometheus.Collector {
+				coll, err := consul_server.NewConsulRaftCollector(*consulServer, c)
+				if err != nil {
+					log.Fatal(err)
+				}
+				return coll
+			},
+			func(*consul_server.HttpClient) prometheus.Collector {
+				return consul_server.NewConsulHealthCollector(views, healthFilter)
+			},
+			func(c *consul_server.HttpClient) prometheus.Collector {
+				coll, err := consul_server.NewConsulMembersCollector(*consulServer, c)
+				if err != nil {
+					log.Fatal(err)
+				}
+				return coll
+			},
 			func(c *consul_server.HttpClient) prometheus.Collector {
-				coll, err := consul_server.NewConsulServerCollector(*consulServer, c)
+				coll, err := consul_server.NewConsulCoordinateCollector(*consulServer, c, *consulRTTTopN)
 				if err != nil {
 					log.Fatal(err)
 				}
 				return coll
 			},
-		} {
-			c := f(mkConsulHttpClient(*consulServer, *timeout, Auth, certPool))
+		}
+		if *consulKVPrefixes != "" {
+			kvValuePattern := compileRegexp(*consulKVValuePattern)
+			consulCollectors = append(consulCollectors, func(*consul_server.HttpClient) prometheus.Collector {
+				return consul_server.NewConsulKVCollector(views, strings.Split(*consulKVPrefixes, ","), kvValuePattern)
+			})
+		}
+		for _, f := range consulCollectors {
+			c := f(mkConsulHttpClient(*consulServer, *timeout, Auth, query, tlsConfig))
 			if _, err := reg.Register(c); err != nil {
 				log.Fatal(err)
 			}
@@ -114,12 +273,28 @@ func main() {
 		if _, err := reg.Register(errorCounter); err != nil {
 			log.Fatal(err)
 		}
-		for _, f := range []func(*httpClient) prometheus.Collector{
+		masterCollectors := []func(*httpClient) prometheus.Collector{
 			newMasterCollector,
+			newAllocatorCollector,
+			newMaintenanceCollector,
+			func(c *httpClient) prometheus.Collector {
+				crashLoop := newCrashLoopDetector(*crashLoopAppLabel, *crashLoopWindow, *crashLoopThreshold)
+				return newMasterStateCollector(c, *ignoreCompletedFrameworkTasks, *completedTaskSeriesLimit, crashLoop, shapes)
+			},
 			func(c *httpClient) prometheus.Collector {
-				return newMasterStateCollector(c, *ignoreCompletedFrameworkTasks)
+				return newBuildInfoCollector(c, flagList, hashExclude)
 			},
-		} {
+		}
+		if *overlay {
+			masterCollectors = append(masterCollectors, newOverlayMasterCollector, newOverlayMasterMetricCollector)
+		}
+		if *processQueueTopN > 0 {
+			masterCollectors = append(masterCollectors, func(c *httpClient) prometheus.Collector {
+				return newProcessesCollector(c, *processQueueTopN)
+			})
+		}
+
+		for _, f := range masterCollectors {
 			c := f(mkHttpClient(*masterURL, *timeout, auth, certPool))
 			if _, err := reg.Register(c); err != nil {
 				log.Fatal(err)
@@ -141,11 +316,29 @@ func main() {
 			func(c *httpClient) prometheus.Collector {
 				return newSlaveMonitorCollector(c)
 			},
+			func(c *httpClient) prometheus.Collector {
+				return newBuildInfoCollector(c, flagList, hashExclude)
+			},
 		}
+		var slaveLabels []string
 		if *exportedTaskLabels != "" {
-			slaveLabels := strings.Split(*exportedTaskLabels, ",")
+			slaveLabels = strings.Split(*exportedTaskLabels, ",")
+		}
+		// Only fetch the agent state if something is exported from it
+		if len(slaveLabels) > 0 || *slaveResources || *resourceProviders {
+			slaveCollectors = append(slaveCollectors, func(c *httpClient) prometheus.Collector {
+				return newSlaveStateCollector(c, slaveLabels, *slaveResources, *resourceProviders)
+			})
+		}
+		if *resourceProviders {
+			slaveCollectors = append(slaveCollectors, newResourceProviderMetricCollector)
+		}
+		if *overlay {
+			slaveCollectors = append(slaveCollectors, newOverlayAgentCollector, newOverlayAgentMetricCollector)
+		}
+		if *processQueueTopN > 0 {
 			slaveCollectors = append(slaveCollectors, func(c *httpClient) prometheus.Collector {
-				return newSlaveStateCollector(c, slaveLabels)
+				return newProcessesCollector(c, *processQueueTopN)
 			})
 		}
 
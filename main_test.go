@@ -2,8 +2,15 @@ package main
 
 import (
 	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus.v2"
 )
 
 func TestPortRange_UnmarshalJSON(t *testing.T) {
@@ -27,3 +34,395 @@ func TestPortRange_UnmarshalJSON(t *testing.T) {
 		}
 	}
 }
+
+func TestKeyInfix(t *testing.T) {
+	for i, tt := range []struct {
+		key, prefix, suffix string
+		want                string
+		ok                  bool
+	}{
+		{"allocator/mesos/offer_filters/roles/web/active", "allocator/mesos/offer_filters/roles/", "/active", "web", true},
+		{"allocator/mesos/offer_filters/roles/eng/web/active", "allocator/mesos/offer_filters/roles/", "/active", "eng/web", true},
+		{"allocator/mesos/offer_filters/roles//active", "allocator/mesos/offer_filters/roles/", "/active", "", false},
+		{"allocator/mesos/allocation_runs", "allocator/mesos/offer_filters/roles/", "/active", "", false},
+		{"allocator/mesos/offer_filters/roles/active", "allocator/mesos/offer_filters/roles/", "/active", "", false},
+	} {
+		got, ok := keyInfix(tt.key, tt.prefix, tt.suffix)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("test #%d: got: %q, %v, want: %q, %v", i, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestHealthChanges(t *testing.T) {
+	healthy, unhealthy := true, false
+	running := func(id string, h *bool) task {
+		return task{ID: id, State: "TASK_RUNNING", Statuses: []status{{Healthy: h}}}
+	}
+	for i, tt := range []struct {
+		last  map[string]bool
+		tasks []task
+		want  map[string]bool
+	}{
+		{map[string]bool{}, nil, map[string]bool{}},
+		{map[string]bool{}, []task{running("a", &healthy)}, map[string]bool{}},
+		{map[string]bool{}, []task{running("a", &unhealthy)}, map[string]bool{"a": false}},
+		{map[string]bool{"a": true}, []task{running("a", &healthy)}, map[string]bool{}},
+		{map[string]bool{"a": true}, []task{running("a", &unhealthy)}, map[string]bool{"a": false}},
+		{map[string]bool{"a": false}, []task{running("a", &healthy)}, map[string]bool{"a": true}},
+		{map[string]bool{"a": false}, []task{running("a", nil)}, map[string]bool{}},
+		{map[string]bool{}, []task{{ID: "a", State: "TASK_FINISHED", Statuses: []status{{Healthy: &unhealthy}}}}, map[string]bool{}},
+	} {
+		st := state{Frameworks: []framework{{Tasks: tt.tasks}}}
+		changes, _ := healthChanges(&st, tt.last)
+		got := map[string]bool{}
+		for _, ch := range changes {
+			got[ch.task.ID] = ch.healthy
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("test #%d: got: %v, want: %v", i, got, tt.want)
+		}
+	}
+}
+
+func TestCrashLoopDetector_Update(t *testing.T) {
+	now := float64(time.Now().Unix())
+	failed := func(id string, at float64) task {
+		return task{ID: id, Name: "web", State: "TASK_FAILED", Statuses: []status{{Timestamp: at}}}
+	}
+	scrape := func(fetched bool, completed ...task) *state {
+		return &state{Frameworks: []framework{{ID: "f", Completed: completed}}, fetched: fetched}
+	}
+	for i, tt := range []struct {
+		scrapes      []*state
+		failures     int
+		crashlooping bool
+	}{
+		{[]*state{scrape(true)}, 0, false},
+		{[]*state{scrape(true, failed("a", now))}, 1, false},
+		{[]*state{scrape(true, failed("a", now)), scrape(true, failed("a", now))}, 1, false},
+		{[]*state{scrape(true, failed("a", now)), scrape(true, failed("a", now), failed("b", now))}, 2, true},
+		// A failed fetch must not make retained failures count again
+		{[]*state{scrape(true, failed("a", now)), scrape(false), scrape(true, failed("a", now))}, 1, false},
+		// Failures outside the window are dropped
+		{[]*state{scrape(true, failed("a", now-3600), failed("b", now))}, 1, false},
+	} {
+		d := newCrashLoopDetector("", 10*time.Minute, 2)
+		for _, st := range tt.scrapes {
+			// Every metric updates the detector
+			d.update(st)
+			d.update(st)
+		}
+		failures := d.failures[appKey{"f", "web"}]
+		if len(failures) != tt.failures || (len(failures) >= d.threshold) != tt.crashlooping {
+			t.Errorf("test #%d: got: %d failures, want: %d", i, len(failures), tt.failures)
+		}
+	}
+}
+
+func TestNewlyCompleted(t *testing.T) {
+	completed := func(ids ...string) *state {
+		f := framework{}
+		for _, id := range ids {
+			f.Completed = append(f.Completed, task{ID: id, Statuses: []status{{Timestamp: 1}}})
+		}
+		return &state{Frameworks: []framework{f}}
+	}
+	for i, tt := range []struct {
+		counted map[completedKey]bool
+		st      *state
+		want    []string
+	}{
+		{map[completedKey]bool{}, completed(), []string{}},
+		{map[completedKey]bool{}, completed("a", "b"), []string{"a", "b"}},
+		{map[completedKey]bool{{"a", 1}: true}, completed("a", "b"), []string{"b"}},
+		{map[completedKey]bool{{"a", 1}: true, {"b", 1}: true}, completed("b"), []string{}},
+		// A reused task ID with a different final status is a new run
+		{map[completedKey]bool{{"a", 0.5}: true}, completed("a"), []string{"a"}},
+	} {
+		tasks, _ := newlyCompleted(tt.st, tt.counted)
+		got := []string{}
+		for _, ft := range tasks {
+			got = append(got, ft.task.ID)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("test #%d: got: %v, want: %v", i, got, tt.want)
+		}
+	}
+}
+
+func TestOverlayInfo_Capacity(t *testing.T) {
+	for i, tt := range []struct {
+		overlay overlayInfo
+		subnets float64
+		ok      bool
+	}{
+		{overlayInfo{Subnet: "9.0.0.0/8", Prefix: 24}, 65536, true},
+		{overlayInfo{Subnet: "9.0.0.0/8", Prefix: 8}, 1, true},
+		{overlayInfo{Subnet: "fd01:b::/64", Prefix: 80}, 65536, true},
+		{overlayInfo{Subnet: "9.0.0.0/24", Prefix: 16}, 0, false},
+		{overlayInfo{Subnet: "9.0.0.0/8", Prefix: 33}, 0, false},
+		{overlayInfo{Subnet: "bogus", Prefix: 24}, 0, false},
+	} {
+		subnets, ok := tt.overlay.capacity()
+		if subnets != tt.subnets || ok != tt.ok {
+			t.Errorf("test #%d: got: %v, %v, want: %v, %v", i, subnets, ok, tt.subnets, tt.ok)
+		}
+	}
+}
+
+func TestTopProcesses(t *testing.T) {
+	queued := func(id string, n int) process {
+		return process{ID: id, Events: make([]processEvent, n)}
+	}
+	ps := []process{queued("idle", 0), queued("master", 3), queued("log", 1), queued("allocator", 5)}
+	for i, tt := range []struct {
+		topN int
+		want []string
+	}{
+		{0, []string{}},
+		{2, []string{"allocator", "master"}},
+		{10, []string{"allocator", "master", "log"}},
+	} {
+		got := []string{}
+		for _, p := range topProcesses(ps, tt.topN) {
+			got = append(got, p.ID)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("test #%d: got: %v, want: %v", i, got, tt.want)
+		}
+	}
+}
+
+func TestTaskShape_Fits(t *testing.T) {
+	shapes, err := parseTaskShapes("small=0.5:512,large=4:16384:10240:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	free := resources{CPUs: 8, Mem: 32768, Disk: 20480, GPUs: 1}
+	for i, tt := range []struct {
+		shape taskShape
+		want  int
+	}{
+		{shapes[0], 16},
+		{shapes[1], 1},
+		{taskShape{"empty", resources{}}, 0},
+	} {
+		if got := tt.shape.fits(free); got != tt.want {
+			t.Errorf("test #%d: got: %d, want: %d", i, got, tt.want)
+		}
+	}
+
+	for _, s := range []string{"small", "small=0.5", "=1:1", "small=a:1", "small=1:1:1:1:1"} {
+		if _, err := parseTaskShapes(s); err == nil {
+			t.Errorf("expected error parsing %q", s)
+		}
+	}
+}
+
+func TestSlave_Free(t *testing.T) {
+	scalar := func(name string, value float64, role string) resourceFull {
+		r := resourceFull{Name: name, Type: "SCALAR", Role: role}
+		r.Scalar.Value = value
+		return r
+	}
+	for i, tt := range []struct {
+		slave       slave
+		free        resources
+		schedulable bool
+	}{
+		{slave{Active: true, Unreserved: resources{CPUs: 4, Mem: 4096}}, resources{CPUs: 4, Mem: 4096}, true},
+		// Tasks using reserved resources leave the unreserved ones free
+		{slave{
+			Active:     true,
+			Unreserved: resources{CPUs: 4, Mem: 4096},
+			Total:      resources{CPUs: 8, Mem: 8192},
+			UsedFull:   []resourceFull{scalar("cpus", 3, "db"), scalar("cpus", 1, "*"), scalar("mem", 1024, "*")},
+		}, resources{CPUs: 3, Mem: 3072}, true},
+		// Outstanding offers are not free
+		{slave{
+			Active:      true,
+			Unreserved:  resources{CPUs: 4, Mem: 4096},
+			OfferedFull: []resourceFull{scalar("cpus", 2, ""), scalar("mem", 2048, "")},
+		}, resources{CPUs: 2, Mem: 2048}, true},
+		{slave{Active: false, Unreserved: resources{CPUs: 4}}, resources{CPUs: 4}, false},
+		{slave{Active: true, Deactivated: true, Unreserved: resources{CPUs: 4}}, resources{CPUs: 4}, false},
+		{slave{Active: true, DrainInfo: &drainInfo{State: "DRAINING"}, Unreserved: resources{CPUs: 4}}, resources{CPUs: 4}, false},
+	} {
+		if got := tt.slave.free(); got != tt.free {
+			t.Errorf("test #%d: got: %+v, want: %+v", i, got, tt.free)
+		}
+		if got := tt.slave.schedulable(); got != tt.schedulable {
+			t.Errorf("test #%d: got schedulable: %v, want: %v", i, got, tt.schedulable)
+		}
+	}
+}
+
+// Synthetic reserved_resources_full of an agent with a static reservation,
+// a dynamic reservation in both formats and a persistent volume on a MOUNT
+// disk.
+const slaveReservedResourcesJSON = `{
+  "reserved_resources_full": {
+    "db": [{
+      "name": "cpus", "type": "SCALAR", "scalar": {"value": 2.0}, "role": "db"
+    }, {
+      "name": "mem", "type": "SCALAR", "scalar": {"value": 1024.0}, "role": "db",
+      "reservation": {"principal": "ops"}
+    }, {
+      "name": "disk", "type": "SCALAR", "scalar": {"value": 2048.0},
+      "reservations": [{"type": "DYNAMIC", "role": "db", "principal": "ops"}],
+      "disk": {"persistence": {"id": "pv-1", "principal": "ops"}, "source": {"type": "MOUNT"}}
+    }]
+  },
+  "unreserved_resources_full": [{
+    "name": "disk", "type": "SCALAR", "scalar": {"value": 512.0}, "role": "*"
+  }]
+}`
+
+func TestSlaveState_ReservedResources(t *testing.T) {
+	var st slaveState
+	if err := json.Unmarshal([]byte(slaveReservedResourcesJSON), &st); err != nil {
+		t.Fatal(err)
+	}
+	rs := st.ReservedResourcesFull["db"]
+	if len(rs) != 3 {
+		t.Fatalf("got %d reserved resources, want 3", len(rs))
+	}
+	for i, tt := range []struct {
+		r               resourceFull
+		name            string
+		reserved        bool
+		reservationType string
+		diskSource      string
+		persistenceID   string
+	}{
+		{rs[0], "cpus", true, "static", "root", ""},
+		{rs[1], "mem", true, "dynamic", "root", ""},
+		{rs[2], "disk", true, "dynamic", "mount", "pv-1"},
+		{st.UnreservedResourcesFull[0], "disk", false, "static", "root", ""},
+	} {
+		if tt.r.Name != tt.name || tt.r.reserved() != tt.reserved || tt.r.reservationType() != tt.reservationType ||
+			tt.r.diskSource() != tt.diskSource || tt.r.persistenceID() != tt.persistenceID {
+			t.Errorf("test #%d: got: %s %v %s %s %q", i, tt.r.Name, tt.r.reserved(), tt.r.reservationType(), tt.r.diskSource(), tt.r.persistenceID())
+		}
+	}
+}
+
+// Synthetic GET_RESOURCE_PROVIDERS response in the format of a Mesos 1.7
+// agent with an LVM storage local resource provider.
+const getResourceProvidersJSON = `{
+  "type": "GET_RESOURCE_PROVIDERS",
+  "get_resource_providers": {
+    "resource_providers": [{
+      "resource_provider_info": {
+        "id": {"value": "1b8e3a5c-1f2d-4a7b-9c6e-3d2f1a0b9c8d"},
+        "type": "org.apache.mesos.rp.local.storage",
+        "name": "lvm"
+      },
+      "total_resources": [{
+        "provider_id": {"value": "1b8e3a5c-1f2d-4a7b-9c6e-3d2f1a0b9c8d"},
+        "name": "disk",
+        "type": "SCALAR",
+        "scalar": {"value": 4096.0},
+        "disk": {"source": {"type": "RAW", "vendor": "org.apache.mesos.csi.lvm", "profile": "fast"}}
+      }, {
+        "provider_id": {"value": "1b8e3a5c-1f2d-4a7b-9c6e-3d2f1a0b9c8d"},
+        "name": "disk",
+        "type": "SCALAR",
+        "scalar": {"value": 1024.0},
+        "disk": {"source": {"type": "MOUNT", "id": "vol-1", "vendor": "org.apache.mesos.csi.lvm"}}
+      }]
+    }]
+  }
+}`
+
+func TestResourceProviderCollector_Decode(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Method != "POST" || r.URL.Path != "/api/v1" || string(body) != `{"type":"GET_RESOURCE_PROVIDERS"}` {
+			t.Errorf("unexpected request: %s %s %s", r.Method, r.URL.Path, body)
+		}
+		w.Write([]byte(getResourceProvidersJSON))
+	}))
+	defer ts.Close()
+
+	c := &httpClient{url: ts.URL}
+	var res getResourceProvidersResponse
+	if !c.postAndDecode("/api/v1", map[string]string{"type": "GET_RESOURCE_PROVIDERS"}, &res) {
+		t.Fatal("postAndDecode failed")
+	}
+	providers := res.GetResourceProviders.ResourceProviders
+	if len(providers) != 1 {
+		t.Fatalf("got %d providers, want 1", len(providers))
+	}
+	p := providers[0]
+	if p.Info.Type != "org.apache.mesos.rp.local.storage" || p.Info.Name != "lvm" {
+		t.Errorf("got provider %s.%s", p.Info.Type, p.Info.Name)
+	}
+	want := map[string]float64{"disk": 5120}
+	if got := providerResources(p.TotalResources, p.Info.ID.Value); !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+	if got := providerResources(p.TotalResources, "other"); len(got) != 0 {
+		t.Errorf("got resources for unknown provider: %v", got)
+	}
+}
+
+func TestResourceProviderUp(t *testing.T) {
+	providers := func(ids ...string) *slaveState {
+		res := &getResourceProvidersResponse{}
+		for _, id := range ids {
+			var p resourceProvider
+			p.Info.ID.Value, p.Info.Type, p.Info.Name = id, "org.apache.mesos.rp.local.storage", "lvm"
+			res.GetResourceProviders.ResourceProviders = append(res.GetResourceProviders.ResourceProviders, p)
+		}
+		return &slaveState{providers: res}
+	}
+	for i, tt := range []struct {
+		scrapes []*slaveState
+		present map[string]bool
+	}{
+		{[]*slaveState{providers("a")}, map[string]bool{"a": true}},
+		// A provider which is gone stays reported as down
+		{[]*slaveState{providers("a"), providers(), providers()}, map[string]bool{"a": true}},
+		// A failed query changes nothing
+		{[]*slaveState{providers("a"), {}}, map[string]bool{"a": true}},
+		// A provider coming back with a new ID replaces the old series
+		{[]*slaveState{providers("a"), providers(), providers("b")}, map[string]bool{"a": false, "b": true}},
+	} {
+		var up *prometheus.GaugeVec
+		var set func(*slaveState, prometheus.Collector)
+		for c, f := range resourceProviderMetrics() {
+			ch := make(chan *prometheus.Desc, 1)
+			c.Describe(ch)
+			if strings.Contains((<-ch).String(), "mesos_slave_resource_provider_up") {
+				up, set = c.(*prometheus.GaugeVec), f
+			}
+		}
+		for _, st := range tt.scrapes {
+			set(st, up)
+		}
+		for id, want := range tt.present {
+			if got := up.DeleteLabelValues(id, "org.apache.mesos.rp.local.storage", "lvm"); got != want {
+				t.Errorf("test #%d: series of %s present: %v, want: %v", i, id, got, want)
+			}
+		}
+	}
+}
+
+func TestSplitResourceProviderKey(t *testing.T) {
+	for i, tt := range []struct {
+		key, provider, rest string
+		ok                  bool
+	}{
+		{"resource_providers/org.apache.mesos.rp.local.storage.lvm/csi_plugin/rpcs_failed", "org.apache.mesos.rp.local.storage.lvm", "csi_plugin/rpcs_failed", true},
+		{"resource_providers/org.apache.mesos.rp.local.storage.lvm/operations/create_disk/finished", "org.apache.mesos.rp.local.storage.lvm", "operations/create_disk/finished", true},
+		{"resource_providers/lvm", "", "", false},
+		{"slave/uptime_secs", "", "", false},
+	} {
+		provider, rest, ok := splitResourceProviderKey(tt.key)
+		if provider != tt.provider || rest != tt.rest || ok != tt.ok {
+			t.Errorf("test #%d: got: %q, %q, %v", i, provider, rest, ok)
+		}
+	}
+}
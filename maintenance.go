@@ -0,0 +1,174 @@
+// Scrape the master /maintenance/schedule and /maintenance/status endpoints to
+// follow machines through the maintenance primitives. Information scraped at
+// this point:
+//
+// * Mode of every scheduled machine ("mesos_maintenance_machine_mode" series)
+// * Unavailability window of every scheduled machine
+// * Inverse offer responses of draining machines by framework
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus.v2"
+)
+
+type (
+	machineID struct {
+		Hostname string `json:"hostname"`
+		IP       string `json:"ip"`
+	}
+
+	nanoseconds struct {
+		Nanoseconds int64 `json:"nanoseconds"`
+	}
+
+	unavailability struct {
+		Start    nanoseconds  `json:"start"`
+		Duration *nanoseconds `json:"duration"`
+	}
+
+	maintenanceWindow struct {
+		MachineIDs     []machineID    `json:"machine_ids"`
+		Unavailability unavailability `json:"unavailability"`
+	}
+
+	maintenanceSchedule struct {
+		Windows []maintenanceWindow `json:"windows"`
+	}
+
+	inverseOfferStatus struct {
+		Status      string `json:"status"`
+		FrameworkID struct {
+			Value string `json:"value"`
+		} `json:"framework_id"`
+	}
+
+	drainingMachine struct {
+		ID       machineID            `json:"id"`
+		Statuses []inverseOfferStatus `json:"statuses"`
+	}
+
+	maintenanceStatus struct {
+		DrainingMachines []drainingMachine `json:"draining_machines"`
+		DownMachines     []machineID       `json:"down_machines"`
+	}
+
+	maintenance struct {
+		schedule maintenanceSchedule
+		status   maintenanceStatus
+		// False if the status couldn't be fetched, machine modes are
+		// unknown then
+		statusOK bool
+	}
+
+	maintenanceCollector struct {
+		*httpClient
+		metrics map[prometheus.Collector]func(*maintenance, prometheus.Collector)
+	}
+)
+
+var machineModes = []string{"up", "draining", "down"}
+
+// Return the mode of every machine known to the maintenance endpoints.
+// Scheduled machines which are neither draining nor down are up.
+func (mt *maintenance) machineModes() map[machineID]string {
+	modes := map[machineID]string{}
+	for _, w := range mt.schedule.Windows {
+		for _, id := range w.MachineIDs {
+			modes[id] = "up"
+		}
+	}
+	for _, m := range mt.status.DrainingMachines {
+		modes[m.ID] = "draining"
+	}
+	for _, id := range mt.status.DownMachines {
+		modes[id] = "down"
+	}
+	return modes
+}
+
+func newMaintenanceCollector(httpClient *httpClient) prometheus.Collector {
+	labels := []string{"hostname", "ip"}
+	metrics := map[prometheus.Collector]func(*maintenance, prometheus.Collector){
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "1 if the machine is in the given maintenance mode, 0 if not",
+			Namespace: "mesos",
+			Subsystem: "maintenance",
+			Name:      "machine_mode",
+		}, []string{"hostname", "ip", "mode"}): func(mt *maintenance, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			if !mt.statusOK {
+				return
+			}
+			for id, mode := range mt.machineModes() {
+				for _, m := range machineModes {
+					value := 0.0
+					if m == mode {
+						value = 1
+					}
+					c.(*prometheus.GaugeVec).WithLabelValues(id.Hostname, id.IP, m).Set(value)
+				}
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "Start of the machine's unavailability window in seconds since epoch",
+			Namespace: "mesos",
+			Subsystem: "maintenance",
+			Name:      "window_start_timestamp_seconds",
+		}, labels): func(mt *maintenance, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, w := range mt.schedule.Windows {
+				for _, id := range w.MachineIDs {
+					c.(*prometheus.GaugeVec).WithLabelValues(id.Hostname, id.IP).Set(float64(w.Unavailability.Start.Nanoseconds) / 1e9)
+				}
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "End of the machine's unavailability window in seconds since epoch, unset for open-ended windows",
+			Namespace: "mesos",
+			Subsystem: "maintenance",
+			Name:      "window_end_timestamp_seconds",
+		}, labels): func(mt *maintenance, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, w := range mt.schedule.Windows {
+				if w.Unavailability.Duration == nil {
+					continue
+				}
+				end := w.Unavailability.Start.Nanoseconds + w.Unavailability.Duration.Nanoseconds
+				for _, id := range w.MachineIDs {
+					c.(*prometheus.GaugeVec).WithLabelValues(id.Hostname, id.IP).Set(float64(end) / 1e9)
+				}
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "Current number of inverse offers for draining machines by framework and response",
+			Namespace: "mesos",
+			Subsystem: "maintenance",
+			Name:      "inverse_offers",
+		}, []string{"framework", "status"}): func(mt *maintenance, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, m := range mt.status.DrainingMachines {
+				for _, s := range m.Statuses {
+					c.(*prometheus.GaugeVec).WithLabelValues(s.FrameworkID.Value, s.Status).Inc()
+				}
+			}
+		},
+	}
+
+	return &maintenanceCollector{httpClient, metrics}
+}
+
+func (c *maintenanceCollector) Collect(ch chan<- prometheus.Metric) {
+	var mt maintenance
+	c.fetchAndDecode("/maintenance/schedule", &mt.schedule)
+	mt.statusOK = c.fetchAndDecode("/maintenance/status", &mt.status)
+	for c, set := range c.metrics {
+		set(&mt, c)
+		c.Collect(ch)
+	}
+}
+
+func (c *maintenanceCollector) Describe(ch chan<- *prometheus.Desc) {
+	for metric := range c.metrics {
+		metric.Describe(ch)
+	}
+}
@@ -57,6 +57,69 @@ func newMasterCollector(httpClient *httpClient) prometheus.Collector {
 			c.(*prometheus.GaugeVec).WithLabelValues(elected, "disk_used").Set(used)
 			return nil
 		},
+		gauge("master", "gpus", "Current GPU resources in cluster.", "elected", "type"): func(m metricMap, c prometheus.Collector) error {
+			total, ok := m["master/gpus_total"]
+			used, ok2 := m["master/gpus_used"]
+			if !ok || !ok2 {
+				return notPublishedInMap
+			}
+			c.(*prometheus.GaugeVec).WithLabelValues(elected, "gpu_free").Set(total - used)
+			c.(*prometheus.GaugeVec).WithLabelValues(elected, "gpu_used").Set(used)
+			return nil
+		},
+		// Revocable CPU/Disk/Mem/GPU resources in free/used
+		gauge("master", "cpus_revocable", "Current revocable CPU resources in cluster.", "elected", "type"): func(m metricMap, c prometheus.Collector) error {
+			total, ok := m["master/cpus_revocable_total"]
+			used, ok2 := m["master/cpus_revocable_used"]
+			if !ok || !ok2 {
+				return notPublishedInMap
+			}
+			c.(*prometheus.GaugeVec).WithLabelValues(elected, "cpu_free").Set(total - used)
+			c.(*prometheus.GaugeVec).WithLabelValues(elected, "cpu_used").Set(used)
+			return nil
+		},
+		gauge("master", "mem_revocable", "Current revocable memory resources in cluster.", "elected", "type"): func(m metricMap, c prometheus.Collector) error {
+			total, ok := m["master/mem_revocable_total"]
+			used, ok2 := m["master/mem_revocable_used"]
+			if !ok || !ok2 {
+				return notPublishedInMap
+			}
+			c.(*prometheus.GaugeVec).WithLabelValues(elected, "mem_free").Set(total - used)
+			c.(*prometheus.GaugeVec).WithLabelValues(elected, "mem_used").Set(used)
+			return nil
+		},
+		gauge("master", "disk_revocable", "Current revocable disk resources in cluster.", "elected", "type"): func(m metricMap, c prometheus.Collector) error {
+			total, ok := m["master/disk_revocable_total"]
+			used, ok2 := m["master/disk_revocable_used"]
+			if !ok || !ok2 {
+				return notPublishedInMap
+			}
+			c.(*prometheus.GaugeVec).WithLabelValues(elected, "disk_free").Set(total - used)
+			c.(*prometheus.GaugeVec).WithLabelValues(elected, "disk_used").Set(used)
+			return nil
+		},
+		gauge("master", "gpus_revocable", "Current revocable GPU resources in cluster.", "elected", "type"): func(m metricMap, c prometheus.Collector) error {
+			total, ok := m["master/gpus_revocable_total"]
+			used, ok2 := m["master/gpus_revocable_used"]
+			if !ok || !ok2 {
+				return notPublishedInMap
+			}
+			c.(*prometheus.GaugeVec).WithLabelValues(elected, "gpu_free").Set(total - used)
+			c.(*prometheus.GaugeVec).WithLabelValues(elected, "gpu_used").Set(used)
+			return nil
+		},
+		gauge("master", "resources_used_ratio", "Fraction of cluster resources in use by resource.", "elected", "resource"): func(m metricMap, c prometheus.Collector) error {
+			return setGaugesFound(m, c.(*prometheus.GaugeVec), map[string][]string{
+				"master/cpus_percent":           {elected, "cpus"},
+				"master/mem_percent":            {elected, "mem"},
+				"master/disk_percent":           {elected, "disk"},
+				"master/gpus_percent":           {elected, "gpus"},
+				"master/cpus_revocable_percent": {elected, "cpus_revocable"},
+				"master/mem_revocable_percent":  {elected, "mem_revocable"},
+				"master/disk_revocable_percent": {elected, "disk_revocable"},
+				"master/gpus_revocable_percent": {elected, "gpus_revocable"},
+			})
+		},
 		prometheus.NewGauge(prometheus.GaugeOpts{
 			Namespace: "mesos",
 			Subsystem: "master",
@@ -115,6 +178,18 @@ func newMasterCollector(httpClient *httpClient) prometheus.Collector {
 			// Every "connected" node is either active or inactive
 			return nil
 		},
+		gauge("master", "slaves_unreachable", "Current number of slaves marked unreachable by this master.", "elected"): func(m metricMap, c prometheus.Collector) error {
+			return setGaugesFound(m, c.(*prometheus.GaugeVec), map[string][]string{
+				"master/slaves_unreachable": {elected},
+			})
+		},
+		counter("master", "slave_unreachable_events_total", "Total number of slaves scheduled, completed and canceled to be marked unreachable.", "elected", "event"): func(m metricMap, c prometheus.Collector) error {
+			return setCountersFound(m, c.(*settableCounterVec), map[string][]string{
+				"master/slave_unreachable_scheduled": {elected, "scheduled"},
+				"master/slave_unreachable_completed": {elected, "completed"},
+				"master/slave_unreachable_canceled":  {elected, "canceled"},
+			})
+		},
 
 		// Master stats about frameworks
 		gauge("master", "frameworks_state", "Current number of frames known to the master per connection and registration state.", "elected", "connection_state", "registration_state"): func(m metricMap, c prometheus.Collector) error {
@@ -164,6 +239,13 @@ func newMasterCollector(httpClient *httpClient) prometheus.Collector {
 			c.(*settableCounterVec).Set(finished, elected, "finished")
 			c.(*settableCounterVec).Set(killed, elected, "killed")
 			c.(*settableCounterVec).Set(lost, elected, "lost")
+			// Partition-aware states are only published by newer masters
+			if gone, ok := m["master/tasks_gone"]; ok {
+				c.(*settableCounterVec).Set(gone, elected, "gone")
+			}
+			if goneByOperator, ok := m["master/tasks_gone_by_operator"]; ok {
+				c.(*settableCounterVec).Set(goneByOperator, elected, "gone_by_operator")
+			}
 			return nil
 		},
 		counter("master", "task_states_current", "Current number of tasks by state.", "elected", "state"): func(m metricMap, c prometheus.Collector) error {
@@ -176,6 +258,9 @@ func newMasterCollector(httpClient *httpClient) prometheus.Collector {
 			c.(*settableCounterVec).Set(running, elected, "running")
 			c.(*settableCounterVec).Set(staging, elected, "staging")
 			c.(*settableCounterVec).Set(starting, elected, "starting")
+			if unreachable, ok := m["master/tasks_unreachable"]; ok {
+				c.(*settableCounterVec).Set(unreachable, elected, "unreachable")
+			}
 			return nil
 		},
 
@@ -249,5 +334,8 @@ func newMasterCollector(httpClient *httpClient) prometheus.Collector {
 			return nil
 		},
 	}
+	for c, f := range systemMetrics("master") {
+		metrics[c] = f
+	}
 	return newMetricCollector(httpClient, metrics)
 }
@@ -4,37 +4,80 @@ import (
 	"bytes"
 	"fmt"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus.v2"
 )
 
 type (
 	slave struct {
-		PID        string    `json:"pid"`
-		Used       resources `json:"used_resources"`
-		Unreserved resources `json:"unreserved_resources"`
-		Total      resources `json:"resources"`
+		PID         string         `json:"pid"`
+		Used        resources      `json:"used_resources"`
+		UsedFull    []resourceFull `json:"used_resources_full"`
+		OfferedFull []resourceFull `json:"offered_resources_full"`
+		Unreserved  resources      `json:"unreserved_resources"`
+		Total       resources      `json:"resources"`
+		Active      bool           `json:"active"`
+		Deactivated bool           `json:"deactivated"`
+		DrainInfo   *drainInfo     `json:"drain_info"`
+	}
+
+	drainInfo struct {
+		State string `json:"state"`
 	}
 
 	framework struct {
-		Active    bool   `json:"active"`
-		Tasks     []task `json:"tasks"`
-		Completed []task `json:"completed_tasks"`
+		ID          string  `json:"id"`
+		Name        string  `json:"name"`
+		Active      bool    `json:"active"`
+		Tasks       []task  `json:"tasks"`
+		Completed   []task  `json:"completed_tasks"`
+		Unreachable []task  `json:"unreachable_tasks"`
+		Offers      []offer `json:"offers"`
+	}
+
+	offer struct {
+		ID          string    `json:"id"`
+		FrameworkID string    `json:"framework_id"`
+		SlaveID     string    `json:"slave_id"`
+		Resources   resources `json:"resources"`
+	}
+
+	unreachableSlave struct {
+		ID        string      `json:"id"`
+		Timestamp nanoseconds `json:"timestamp"`
+	}
+
+	recoveredSlave struct {
+		ID string `json:"id"`
 	}
 
 	state struct {
-		Slaves     []slave     `json:"slaves"`
-		Frameworks []framework `json:"frameworks"`
+		Slaves            []slave            `json:"slaves"`
+		RecoveredSlaves   []recoveredSlave   `json:"recovered_slaves"`
+		UnreachableSlaves []unreachableSlave `json:"unreachable_slaves"`
+		Frameworks        []framework        `json:"frameworks"`
+
+		// False if /state couldn't be fetched. Metrics remembering
+		// something across scrapes must leave it alone then.
+		fetched bool
 	}
 
 	masterCollector struct {
 		*httpClient
+		// Serialises scrapes, several metrics keep state across them
+		sync.Mutex
 		metrics map[prometheus.Collector]func(*state, prometheus.Collector)
 	}
 )
 
-func newMasterStateCollector(httpClient *httpClient, ignoreFrameworkTasks bool) prometheus.Collector {
+func newMasterStateCollector(httpClient *httpClient, ignoreFrameworkTasks bool, completedTaskSeriesLimit int, crashLoop *crashLoopDetector, shapes []taskShape) prometheus.Collector {
 	labels := []string{"slave"}
+	// Mesos doesn't publish when an offer was made, so remember when each
+	// offer was first seen to compute the age of outstanding offers.
+	offerFirstSeen := map[string]time.Time{}
 	metrics := map[prometheus.Collector]func(*state, prometheus.Collector){
 		prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Help:      "Total slave CPUs (fractional)",
@@ -159,33 +202,188 @@ func newMasterStateCollector(httpClient *httpClient, ignoreFrameworkTasks bool)
 				c.(*prometheus.GaugeVec).WithLabelValues(s.PID).Set(float64(size))
 			}
 		},
-	}
-
-	if !ignoreFrameworkTasks {
-		metrics[prometheus.NewGaugeVec(prometheus.GaugeOpts{
-			Help:      "Completed framework tasks",
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "1 if the slave is deactivated and not offered to frameworks, 0 if not",
 			Namespace: "mesos",
 			Subsystem: "slave",
-			Name:      "task_state_time",
-		}, []string{"slave", "task", "executor", "name", "framework", "state"})] = func(st *state, c prometheus.Collector) {
-			for _, f := range st.Frameworks {
-				if !f.Active {
+			Name:      "deactivated",
+		}, labels): func(st *state, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, s := range st.Slaves {
+				deactivated := 0.0
+				if s.Deactivated {
+					deactivated = 1
+				}
+				c.(*prometheus.GaugeVec).WithLabelValues(s.PID).Set(deactivated)
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "1 if the slave is in the given drain state, only present for draining slaves",
+			Namespace: "mesos",
+			Subsystem: "slave",
+			Name:      "drain_state",
+		}, []string{"slave", "state"}): func(st *state, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, s := range st.Slaves {
+				if s.DrainInfo == nil {
 					continue
 				}
-				for _, task := range f.Completed {
-					values := []string{
-						task.ID,
-						task.SlaveID,
-						task.ExecutorID,
-						task.Name,
-						task.FrameworkID,
-						task.State,
+				c.(*prometheus.GaugeVec).WithLabelValues(s.PID, strings.ToLower(s.DrainInfo.State)).Set(1)
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "Time the slave was marked unreachable in seconds since epoch",
+			Namespace: "mesos",
+			Subsystem: "slave",
+			Name:      "unreachable_since_timestamp_seconds",
+		}, []string{"slave_id"}): func(st *state, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, s := range st.UnreachableSlaves {
+				c.(*prometheus.GaugeVec).WithLabelValues(s.ID).Set(float64(s.Timestamp.Nanoseconds) / 1e9)
+			}
+		},
+		prometheus.NewGauge(prometheus.GaugeOpts{
+			Help:      "Current number of slaves recovered from the registry which have not re-registered yet",
+			Namespace: "mesos",
+			Subsystem: "master",
+			Name:      "recovered_slaves",
+		}): func(st *state, c prometheus.Collector) {
+			c.(prometheus.Gauge).Set(float64(len(st.RecoveredSlaves)))
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "Current number of unreachable tasks by framework",
+			Namespace: "mesos",
+			Subsystem: "framework",
+			Name:      "tasks_unreachable",
+		}, []string{"framework_id", "framework"}): func(st *state, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, f := range st.Frameworks {
+				c.(*prometheus.GaugeVec).WithLabelValues(f.ID, f.Name).Set(float64(len(f.Unreachable)))
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "Number of gone tasks among the completed tasks retained by the master, by framework",
+			Namespace: "mesos",
+			Subsystem: "framework",
+			Name:      "tasks_gone",
+		}, []string{"framework_id", "framework", "state"}): func(st *state, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, f := range st.Frameworks {
+				for _, s := range []string{"TASK_GONE", "TASK_GONE_BY_OPERATOR"} {
+					c.(*prometheus.GaugeVec).WithLabelValues(f.ID, f.Name, s).Set(0)
+				}
+				for _, t := range f.Completed {
+					if t.State == "TASK_GONE" || t.State == "TASK_GONE_BY_OPERATOR" {
+						c.(*prometheus.GaugeVec).WithLabelValues(f.ID, f.Name, t.State).Inc()
 					}
-					if len(task.Statuses) > 0 {
-						c.(*prometheus.GaugeVec).WithLabelValues(values...).Set(task.Statuses[0].Timestamp)
+				}
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "Current number of outstanding offers by framework",
+			Namespace: "mesos",
+			Subsystem: "framework",
+			Name:      "offers_outstanding",
+		}, []string{"framework_id", "framework"}): func(st *state, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, f := range st.Frameworks {
+				c.(*prometheus.GaugeVec).WithLabelValues(f.ID, f.Name).Set(float64(len(f.Offers)))
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "Resources held in outstanding offers by framework (cpus fractional, mem and disk in MB)",
+			Namespace: "mesos",
+			Subsystem: "framework",
+			Name:      "offered_resources",
+		}, []string{"framework_id", "framework", "resource"}): func(st *state, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, f := range st.Frameworks {
+				var offered resources
+				for _, o := range f.Offers {
+					offered.CPUs += o.Resources.CPUs
+					offered.Mem += o.Resources.Mem
+					offered.Disk += o.Resources.Disk
+					offered.GPUs += o.Resources.GPUs
+				}
+				c.(*prometheus.GaugeVec).WithLabelValues(f.ID, f.Name, "cpus").Set(offered.CPUs)
+				c.(*prometheus.GaugeVec).WithLabelValues(f.ID, f.Name, "mem").Set(offered.Mem)
+				c.(*prometheus.GaugeVec).WithLabelValues(f.ID, f.Name, "disk").Set(offered.Disk)
+				c.(*prometheus.GaugeVec).WithLabelValues(f.ID, f.Name, "gpus").Set(offered.GPUs)
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "Age of the oldest outstanding offer by framework, measured from the first scrape it was seen in",
+			Namespace: "mesos",
+			Subsystem: "framework",
+			Name:      "oldest_offer_age_seconds",
+		}, []string{"framework_id", "framework"}): func(st *state, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			if !st.fetched {
+				return
+			}
+			now := time.Now()
+			seen := map[string]time.Time{}
+			for _, f := range st.Frameworks {
+				oldest := 0.0
+				for _, o := range f.Offers {
+					first, ok := offerFirstSeen[o.ID]
+					if !ok {
+						first = now
+					}
+					seen[o.ID] = first
+					if age := now.Sub(first).Seconds(); age > oldest {
+						oldest = age
 					}
 				}
+				c.(*prometheus.GaugeVec).WithLabelValues(f.ID, f.Name).Set(oldest)
 			}
+			// Forget offers which have been accepted, declined or rescinded
+			offerFirstSeen = seen
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "Current number of outstanding offers by slave",
+			Namespace: "mesos",
+			Subsystem: "slave",
+			Name:      "offers_outstanding",
+		}, []string{"slave_id"}): func(st *state, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, f := range st.Frameworks {
+				for _, o := range f.Offers {
+					c.(*prometheus.GaugeVec).WithLabelValues(o.SlaveID).Inc()
+				}
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "Resources held in outstanding offers by slave (cpus fractional, mem and disk in MB)",
+			Namespace: "mesos",
+			Subsystem: "slave",
+			Name:      "offered_resources",
+		}, []string{"slave_id", "resource"}): func(st *state, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, f := range st.Frameworks {
+				for _, o := range f.Offers {
+					c.(*prometheus.GaugeVec).WithLabelValues(o.SlaveID, "cpus").Add(o.Resources.CPUs)
+					c.(*prometheus.GaugeVec).WithLabelValues(o.SlaveID, "mem").Add(o.Resources.Mem)
+					c.(*prometheus.GaugeVec).WithLabelValues(o.SlaveID, "disk").Add(o.Resources.Disk)
+					c.(*prometheus.GaugeVec).WithLabelValues(o.SlaveID, "gpus").Add(o.Resources.GPUs)
+				}
+			}
+		},
+	}
+
+	for c, f := range taskHealthMetrics() {
+		metrics[c] = f
+	}
+	for c, f := range crashLoop.metrics() {
+		metrics[c] = f
+	}
+	for c, f := range capacityMetrics(shapes) {
+		metrics[c] = f
+	}
+
+	if !ignoreFrameworkTasks {
+		for c, f := range completedTaskMetrics(completedTaskSeriesLimit) {
+			metrics[c] = f
 		}
 	}
 
@@ -196,8 +394,11 @@ func newMasterStateCollector(httpClient *httpClient, ignoreFrameworkTasks bool)
 }
 
 func (c *masterCollector) Collect(ch chan<- prometheus.Metric) {
+	c.Lock()
+	defer c.Unlock()
+
 	var s state
-	c.fetchAndDecode("/state", &s)
+	s.fetched = c.fetchAndDecode("/state", &s)
 
 	for c, set := range c.metrics {
 		set(&s, c)
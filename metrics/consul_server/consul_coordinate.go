@@ -0,0 +1,237 @@
+package consul_server
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	consul_api "github.com/hashicorp/consul/api"
+	"github.com/prometheus/client_golang/prometheus.v2"
+)
+
+type (
+	// Network coordinates of the local datacenter and of the WAN pool
+	coordinates struct {
+		datacenter string
+		servers    map[string]bool
+		nodes      []*consul_api.CoordinateEntry
+		wan        []*consul_api.CoordinateDatacenterMap
+	}
+
+	// Summaries computed anew from the current estimates every scrape
+	constSummaryVec struct {
+		desc   *prometheus.Desc
+		values []prometheus.Metric
+	}
+
+	consulCoordinateCollector struct {
+		client *consulClient
+		// Serialises scrapes, the summaries are rebuilt from shared values
+		sync.Mutex
+		metrics map[prometheus.Collector]func(*coordinates, prometheus.Collector)
+	}
+)
+
+// Estimated round trip time between two coordinate entries. False if either
+// has no coordinate yet or they are from incompatible coordinate systems.
+func rtt(a, b consul_api.CoordinateEntry) (time.Duration, bool) {
+	if a.Coord == nil || b.Coord == nil || !a.Coord.IsCompatibleWith(b.Coord) {
+		return 0, false
+	}
+	return a.Coord.DistanceTo(b.Coord), true
+}
+
+func median(ds []time.Duration) time.Duration {
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	return ds[len(ds)/2]
+}
+
+// Nearest-rank quantile of sorted durations.
+func quantile(sorted []time.Duration, q float64) time.Duration {
+	i := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func (c *constSummaryVec) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *constSummaryVec) Collect(ch chan<- prometheus.Metric) {
+	for _, v := range c.values {
+		ch <- v
+	}
+	c.values = nil
+}
+
+// Add a summary of the durations in seconds.
+func (c *constSummaryVec) Set(ds []time.Duration, objectives []float64, labelValues ...string) {
+	if len(ds) == 0 {
+		return
+	}
+	sorted := append([]time.Duration(nil), ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	sum := 0.0
+	for _, d := range sorted {
+		sum += d.Seconds()
+	}
+	quantiles := map[float64]float64{}
+	for _, q := range objectives {
+		quantiles[q] = quantile(sorted, q).Seconds()
+	}
+	c.values = append(c.values, prometheus.MustNewConstSummary(c.desc, uint64(len(sorted)), sum, quantiles, labelValues...))
+}
+
+// Estimated RTT of every node in the local datacenter from every server,
+// by server and node name.
+func (co *coordinates) serverRTTs() map[string]map[string]time.Duration {
+	rtts := map[string]map[string]time.Duration{}
+	for _, s := range co.nodes {
+		if !co.servers[s.Node] {
+			continue
+		}
+		rtts[s.Node] = map[string]time.Duration{}
+		for _, n := range co.nodes {
+			if n.Node == s.Node {
+				continue
+			}
+			if d, ok := rtt(*s, *n); ok {
+				rtts[s.Node][n.Node] = d
+			}
+		}
+	}
+	return rtts
+}
+
+// NewConsulCoordinateCollector returns a collector estimating round trip
+// times from the Vivaldi network coordinates Consul keeps for every node.
+// The topN nodes with the highest median RTT to the servers are exported
+// individually.
+func NewConsulCoordinateCollector(uri string, httpClient *HttpClient, topN int) (prometheus.Collector, error) {
+	client, err := newConsulClient(uri, httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := map[prometheus.Collector]func(*coordinates, prometheus.Collector){
+		// Only the current estimates are of interest, not past ones, so the
+		// summary only covers the estimates of this scrape.
+		&constSummaryVec{desc: prometheus.NewDesc(
+			prometheus.BuildFQName(consulNameSpace, "coordinate", "node_rtt_seconds"),
+			"Estimated round trip time from the server to the nodes of its datacenter, as of the scrape.",
+			[]string{"datacenter", "server"},
+			nil,
+		)}: func(co *coordinates, c prometheus.Collector) {
+			for server, rtts := range co.serverRTTs() {
+				ds := make([]time.Duration, 0, len(rtts))
+				for _, d := range rtts {
+					ds = append(ds, d)
+				}
+				c.(*constSummaryVec).Set(ds, []float64{0.5, 0.9, 0.99}, co.datacenter, server)
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: consulNameSpace,
+			Subsystem: "coordinate",
+			Name:      "slowest_node_rtt_seconds",
+			Help:      "Median estimated round trip time from the servers to the nodes with the highest estimates.",
+		}, []string{"datacenter", "node"}): func(co *coordinates, c prometheus.Collector) {
+			byNode := map[string][]time.Duration{}
+			for _, rtts := range co.serverRTTs() {
+				for node, d := range rtts {
+					byNode[node] = append(byNode[node], d)
+				}
+			}
+			type nodeRTT struct {
+				node string
+				rtt  time.Duration
+			}
+			nodes := make([]nodeRTT, 0, len(byNode))
+			for node, ds := range byNode {
+				nodes = append(nodes, nodeRTT{node, median(ds)})
+			}
+			sort.Slice(nodes, func(i, j int) bool { return nodes[i].rtt > nodes[j].rtt })
+			if len(nodes) > topN {
+				nodes = nodes[:topN]
+			}
+
+			c.(*prometheus.GaugeVec).Reset()
+			for _, n := range nodes {
+				c.(*prometheus.GaugeVec).WithLabelValues(co.datacenter, n.node).Set(n.rtt.Seconds())
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: consulNameSpace,
+			Subsystem: "coordinate",
+			Name:      "datacenter_rtt_seconds",
+			Help:      "Median estimated round trip time between the servers of two datacenters.",
+		}, []string{"datacenter", "remote_datacenter"}): func(co *coordinates, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, src := range co.wan {
+				for _, dst := range co.wan {
+					if src.Datacenter == dst.Datacenter {
+						continue
+					}
+					ds := []time.Duration{}
+					for _, a := range src.Coordinates {
+						for _, b := range dst.Coordinates {
+							if d, ok := rtt(a, b); ok {
+								ds = append(ds, d)
+							}
+						}
+					}
+					if len(ds) > 0 {
+						c.(*prometheus.GaugeVec).WithLabelValues(src.Datacenter, dst.Datacenter).Set(median(ds).Seconds())
+					}
+				}
+			}
+		},
+	}
+
+	return &consulCoordinateCollector{
+		client:  client,
+		metrics: metrics,
+	}, nil
+}
+
+func (c *consulCoordinateCollector) Collect(ch chan<- prometheus.Metric) {
+	c.Lock()
+	defer c.Unlock()
+
+	co := coordinates{
+		datacenter: c.client.localDatacenter(),
+		servers:    map[string]bool{},
+	}
+
+	// Every server registers the "consul" service in the catalog
+	servers, _, err := c.client.Catalog().Service("consul", "", c.client.queryOptions())
+	if err != nil {
+		queryFailed(co.datacenter, "Catalog().Service(consul)", err)
+		return
+	}
+	for _, s := range servers {
+		co.servers[s.Node] = true
+	}
+	if co.nodes, _, err = c.client.Coordinate().Nodes(c.client.queryOptions()); err != nil {
+		queryFailed(co.datacenter, "Coordinate().Nodes()", err)
+		return
+	}
+	if co.wan, err = c.client.Coordinate().Datacenters(); err != nil {
+		queryFailed(co.datacenter, "Coordinate().Datacenters()", err)
+		return
+	}
+
+	for c, set := range c.metrics {
+		set(&co, c)
+		c.Collect(ch)
+	}
+}
+
+func (c *consulCoordinateCollector) Describe(ch chan<- *prometheus.Desc) {
+	for metric := range c.metrics {
+		metric.Describe(ch)
+	}
+}
@@ -0,0 +1,186 @@
+package consul_server
+
+import (
+	"regexp"
+
+	consul_api "github.com/hashicorp/consul/api"
+	"github.com/prometheus/client_golang/prometheus.v2"
+)
+
+// Include/exclude regexes limiting the services and checks exported by the
+// health collector. Nil regexes match everything (include) or nothing
+// (exclude).
+type HealthFilter struct {
+	ServiceInclude *regexp.Regexp
+	ServiceExclude *regexp.Regexp
+	CheckInclude   *regexp.Regexp
+	CheckExclude   *regexp.Regexp
+}
+
+func matches(include, exclude *regexp.Regexp, s string) bool {
+	if include != nil && !include.MatchString(s) {
+		return false
+	}
+	if exclude != nil && exclude.MatchString(s) {
+		return false
+	}
+	return true
+}
+
+func (f *HealthFilter) service(name string) bool {
+	return matches(f.ServiceInclude, f.ServiceExclude, name)
+}
+
+func (f *HealthFilter) check(id string) bool {
+	return matches(f.CheckInclude, f.CheckExclude, id)
+}
+
+var healthStatuses = []string{
+	consul_api.HealthPassing,
+	consul_api.HealthWarning,
+	consul_api.HealthCritical,
+	consul_api.HealthMaint,
+}
+
+type (
+	serviceInstance struct {
+		node, serviceID string
+	}
+
+	datacenterChecks struct {
+		datacenter string
+		checks     consul_api.HealthChecks
+	}
+
+	consulHealthCollector struct {
+		views   *Views
+		metrics map[prometheus.Collector]func([]datacenterChecks, prometheus.Collector)
+	}
+)
+
+// Group the checks by service instance. Node checks apply to every service
+// instance on the node, the same way Consul computes instance health.
+func serviceInstanceChecks(checks consul_api.HealthChecks) (map[serviceInstance]consul_api.HealthChecks, map[serviceInstance]string) {
+	nodeChecks := map[string]consul_api.HealthChecks{}
+	for _, hc := range checks {
+		if hc.ServiceID == "" {
+			nodeChecks[hc.Node] = append(nodeChecks[hc.Node], hc)
+		}
+	}
+
+	instances := map[serviceInstance]consul_api.HealthChecks{}
+	names := map[serviceInstance]string{}
+	for _, hc := range checks {
+		if hc.ServiceID == "" {
+			continue
+		}
+		i := serviceInstance{hc.Node, hc.ServiceID}
+		if _, ok := instances[i]; !ok {
+			instances[i] = append(consul_api.HealthChecks{}, nodeChecks[hc.Node]...)
+			names[i] = hc.ServiceName
+		}
+		instances[i] = append(instances[i], hc)
+	}
+	return instances, names
+}
+
+func NewConsulHealthCollector(views *Views, filter HealthFilter) prometheus.Collector {
+	metrics := map[prometheus.Collector]func([]datacenterChecks, prometheus.Collector){
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: consulNameSpace,
+			Subsystem: "health",
+			Name:      "check_status",
+			Help:      "1 if the check is in the given status, 0 if not.",
+		}, []string{"datacenter", "node", "service", "check", "status"}): func(dcs []datacenterChecks, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, dc := range dcs {
+				for _, hc := range dc.checks {
+					if !filter.check(hc.CheckID) || (hc.ServiceName != "" && !filter.service(hc.ServiceName)) {
+						continue
+					}
+					for _, status := range healthStatuses {
+						value := 0.0
+						if hc.Status == status {
+							value = 1
+						}
+						c.(*prometheus.GaugeVec).WithLabelValues(dc.datacenter, hc.Node, hc.ServiceName, hc.CheckID, status).Set(value)
+					}
+				}
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: consulNameSpace,
+			Subsystem: "health",
+			Name:      "service_instances",
+			Help:      "Number of service instances by aggregated health status.",
+		}, []string{"datacenter", "service", "status"}): func(dcs []datacenterChecks, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, dc := range dcs {
+				instances, names := serviceInstanceChecks(dc.checks)
+				for i, instanceChecks := range instances {
+					name := names[i]
+					if !filter.service(name) {
+						continue
+					}
+					for _, status := range healthStatuses {
+						c.(*prometheus.GaugeVec).WithLabelValues(dc.datacenter, name, status).Add(0)
+					}
+					if status := instanceChecks.AggregatedStatus(); status != "" {
+						c.(*prometheus.GaugeVec).WithLabelValues(dc.datacenter, name, status).Inc()
+					}
+				}
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: consulNameSpace,
+			Subsystem: "health",
+			Name:      "node_status",
+			Help:      "1 if the node's serfHealth check is in the given status, 0 if not.",
+		}, []string{"datacenter", "node", "status"}): func(dcs []datacenterChecks, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, dc := range dcs {
+				for _, hc := range dc.checks {
+					if hc.CheckID != "serfHealth" {
+						continue
+					}
+					for _, status := range healthStatuses {
+						value := 0.0
+						if hc.Status == status {
+							value = 1
+						}
+						c.(*prometheus.GaugeVec).WithLabelValues(dc.datacenter, hc.Node, status).Set(value)
+					}
+				}
+			}
+		},
+	}
+
+	// Start watching right away so the first scrape has data
+	for _, dc := range views.datacenters() {
+		views.checks(dc)
+	}
+
+	return &consulHealthCollector{
+		views:   views,
+		metrics: metrics,
+	}
+}
+
+func (c *consulHealthCollector) Collect(ch chan<- prometheus.Metric) {
+	dcs := []datacenterChecks{}
+	for _, dc := range c.views.datacenters() {
+		if checks, ok := c.views.checks(dc); ok {
+			dcs = append(dcs, datacenterChecks{dc, checks})
+		}
+	}
+	for c, set := range c.metrics {
+		set(dcs, c)
+		c.Collect(ch)
+	}
+}
+
+func (c *consulHealthCollector) Describe(ch chan<- *prometheus.Desc) {
+	for metric := range c.metrics {
+		metric.Describe(ch)
+	}
+}
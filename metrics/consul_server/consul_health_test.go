@@ -0,0 +1,25 @@
+package consul_server
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestHealthFilter(t *testing.T) {
+	for i, tt := range []struct {
+		filter         HealthFilter
+		service, check string
+		want           [2]bool
+	}{
+		{HealthFilter{}, "web", "serfHealth", [2]bool{true, true}},
+		{HealthFilter{ServiceInclude: regexp.MustCompile("^web")}, "web-1", "", [2]bool{true, true}},
+		{HealthFilter{ServiceInclude: regexp.MustCompile("^web")}, "db", "", [2]bool{false, true}},
+		{HealthFilter{ServiceInclude: regexp.MustCompile("^web"), ServiceExclude: regexp.MustCompile("-canary$")}, "web-canary", "", [2]bool{false, true}},
+		{HealthFilter{CheckExclude: regexp.MustCompile("^serfHealth$")}, "web", "serfHealth", [2]bool{true, false}},
+		{HealthFilter{CheckInclude: regexp.MustCompile("^service:")}, "web", "service:web-1", [2]bool{true, true}},
+	} {
+		if got := [2]bool{tt.filter.service(tt.service), tt.filter.check(tt.check)}; got != tt.want {
+			t.Errorf("test #%d: got: %v, want: %v", i, got, tt.want)
+		}
+	}
+}
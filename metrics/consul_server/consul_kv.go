@@ -0,0 +1,127 @@
+package consul_server
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	consul_api "github.com/hashicorp/consul/api"
+	"github.com/prometheus/client_golang/prometheus.v2"
+)
+
+type (
+	// Latest known contents of a KV prefix
+	kvView struct {
+		prefix string
+		pairs  consul_api.KVPairs
+	}
+
+	consulKVCollector struct {
+		views    *Views
+		prefixes []string
+		metrics  map[prometheus.Collector]func([]*kvView, prometheus.Collector)
+	}
+)
+
+// Parse a KV value as a number, ignoring surrounding whitespace.
+func kvNumber(value []byte) (float64, bool) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(string(value)), 64)
+	return f, err == nil
+}
+
+// NewConsulKVCollector returns a collector for the keys below the given
+// prefixes. Values of keys matching valuePattern are exported if they parse
+// as numbers.
+func NewConsulKVCollector(views *Views, prefixes []string, valuePattern *regexp.Regexp) prometheus.Collector {
+	metrics := map[prometheus.Collector]func([]*kvView, prometheus.Collector){
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: consulNameSpace,
+			Subsystem: "kv",
+			Name:      "keys",
+			Help:      "Number of keys below the prefix.",
+		}, []string{"prefix"}): func(kvs []*kvView, c prometheus.Collector) {
+			for _, v := range kvs {
+				c.(*prometheus.GaugeVec).WithLabelValues(v.prefix).Set(float64(len(v.pairs)))
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: consulNameSpace,
+			Subsystem: "kv",
+			Name:      "value_bytes",
+			Help:      "Total size of the values below the prefix.",
+		}, []string{"prefix"}): func(kvs []*kvView, c prometheus.Collector) {
+			for _, v := range kvs {
+				size := 0
+				for _, p := range v.pairs {
+					size += len(p.Value)
+				}
+				c.(*prometheus.GaugeVec).WithLabelValues(v.prefix).Set(float64(size))
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: consulNameSpace,
+			Subsystem: "kv",
+			Name:      "modify_index",
+			Help:      "Raft index of the last modification of the key.",
+		}, []string{"prefix", "key"}): func(kvs []*kvView, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, v := range kvs {
+				for _, p := range v.pairs {
+					c.(*prometheus.GaugeVec).WithLabelValues(v.prefix, p.Key).Set(float64(p.ModifyIndex))
+				}
+			}
+		},
+	}
+
+	if valuePattern != nil {
+		metrics[prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: consulNameSpace,
+			Subsystem: "kv",
+			Name:      "value",
+			Help:      "Numeric value of the key.",
+		}, []string{"prefix", "key"})] = func(kvs []*kvView, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, v := range kvs {
+				for _, p := range v.pairs {
+					if !valuePattern.MatchString(p.Key) {
+						continue
+					}
+					if f, ok := kvNumber(p.Value); ok {
+						c.(*prometheus.GaugeVec).WithLabelValues(v.prefix, p.Key).Set(f)
+					}
+				}
+			}
+		}
+	}
+
+	// Start watching right away so the first scrape has data
+	for _, prefix := range prefixes {
+		views.kv(prefix)
+	}
+
+	return &consulKVCollector{
+		views:    views,
+		prefixes: prefixes,
+		metrics:  metrics,
+	}
+}
+
+func (c *consulKVCollector) Collect(ch chan<- prometheus.Metric) {
+	kvs := []*kvView{}
+	for _, prefix := range c.prefixes {
+		if pairs, ok := c.views.kv(prefix); ok {
+			kvs = append(kvs, &kvView{prefix, pairs})
+		}
+	}
+
+	for c, set := range c.metrics {
+		set(kvs, c)
+		c.Collect(ch)
+	}
+}
+
+func (c *consulKVCollector) Describe(ch chan<- *prometheus.Desc) {
+	for metric := range c.metrics {
+		metric.Describe(ch)
+	}
+}
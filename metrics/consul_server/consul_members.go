@@ -0,0 +1,148 @@
+package consul_server
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	consul_api "github.com/hashicorp/consul/api"
+	"github.com/prometheus/client_golang/prometheus.v2"
+)
+
+// Serf member status codes as returned by Agent().Members()
+var memberStatuses = map[int]string{
+	0: "none",
+	1: "alive",
+	2: "leaving",
+	3: "left",
+	4: "failed",
+}
+
+func memberStatus(m *consul_api.AgentMember) string {
+	if status, ok := memberStatuses[m.Status]; ok {
+		return status
+	}
+	return strconv.Itoa(m.Status)
+}
+
+func memberRole(m *consul_api.AgentMember) string {
+	if m.Tags["role"] == "consul" {
+		return "server"
+	}
+	return "client"
+}
+
+type (
+	// Gossip members by pool ("lan" or "wan"), only of the pools which
+	// could be queried
+	members map[string][]*consul_api.AgentMember
+
+	consulMembersCollector struct {
+		client *consulClient
+		// Serialises scrapes, member_failures_total keeps state across them
+		sync.Mutex
+		metrics map[prometheus.Collector]func(*members, prometheus.Collector)
+	}
+)
+
+func NewConsulMembersCollector(uri string, httpClient *HttpClient) (prometheus.Collector, error) {
+	client, err := newConsulClient(uri, httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	// Status of every member in the previous scrape, by pool and name
+	lastStatus := map[[2]string]string{}
+
+	metrics := map[prometheus.Collector]func(*members, prometheus.Collector){
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: consulNameSpace,
+			Subsystem: "serf",
+			Name:      "members",
+			Help:      "Number of gossip members by pool and status.",
+		}, []string{"pool", "status"}): func(ms *members, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for pool, poolMembers := range *ms {
+				for _, status := range memberStatuses {
+					c.(*prometheus.GaugeVec).WithLabelValues(pool, status).Set(0)
+				}
+				for _, m := range poolMembers {
+					c.(*prometheus.GaugeVec).WithLabelValues(pool, memberStatus(m)).Inc()
+				}
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: consulNameSpace,
+			Subsystem: "serf",
+			Name:      "member_status",
+			Help:      "Status of the gossip member, always 1.",
+		}, []string{"pool", "member", "address", "role", "datacenter", "build", "status"}): func(ms *members, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for pool, poolMembers := range *ms {
+				for _, m := range poolMembers {
+					c.(*prometheus.GaugeVec).WithLabelValues(pool, m.Name, m.Addr, memberRole(m), m.Tags["dc"], m.Tags["build"], memberStatus(m)).Set(1)
+				}
+			}
+		},
+		prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: consulNameSpace,
+			Subsystem: "serf",
+			Name:      "member_failures_total",
+			Help:      "Number of gossip members observed transitioning to failed.",
+		}, []string{"pool"}): func(ms *members, c prometheus.Collector) {
+			// Pools which couldn't be queried keep their previous status
+			status := map[[2]string]string{}
+			for key, s := range lastStatus {
+				if _, ok := (*ms)[key[0]]; !ok {
+					status[key] = s
+				}
+			}
+			for pool, poolMembers := range *ms {
+				c.(*prometheus.CounterVec).WithLabelValues(pool).Add(0)
+				for _, m := range poolMembers {
+					key := [2]string{pool, m.Name}
+					status[key] = memberStatus(m)
+					if previous, ok := lastStatus[key]; ok && previous != "failed" && status[key] == "failed" {
+						c.(*prometheus.CounterVec).WithLabelValues(pool).Inc()
+					}
+				}
+			}
+			lastStatus = status
+		},
+	}
+
+	return &consulMembersCollector{
+		client:  client,
+		metrics: metrics,
+	}, nil
+}
+
+func (c *consulMembersCollector) Collect(ch chan<- prometheus.Metric) {
+	c.Lock()
+	defer c.Unlock()
+
+	// The pools are queried independently, client agents aren't members
+	// of the WAN pool and the token may only be allowed to list one of them
+	ms := members{}
+	for pool, wan := range map[string]bool{"lan": false, "wan": true} {
+		poolMembers, err := c.client.Agent().Members(wan)
+		if err != nil {
+			queryFailed(c.client.localDatacenter(), fmt.Sprintf("Agent().Members(%v)", wan), err)
+			continue
+		}
+		ms[pool] = poolMembers
+	}
+	if len(ms) == 0 {
+		return
+	}
+	for c, set := range c.metrics {
+		set(&ms, c)
+		c.Collect(ch)
+	}
+}
+
+func (c *consulMembersCollector) Describe(ch chan<- *prometheus.Desc) {
+	for metric := range c.metrics {
+		metric.Describe(ch)
+	}
+}
@@ -0,0 +1,203 @@
+package consul_server
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	consul_api "github.com/hashicorp/consul/api"
+	"github.com/prometheus/client_golang/prometheus.v2"
+)
+
+type (
+	raftState struct {
+		datacenter string
+		// False if the query failed for another reason than a missing
+		// leader, nothing is known about the leader then
+		known  bool
+		leader string
+		config *consul_api.RaftConfiguration
+	}
+
+	consulRaftCollector struct {
+		client  *consulClient
+		metrics map[prometheus.Collector]func([]*raftState, prometheus.Collector)
+
+		// Serialises scrapes, lastLeader is kept across them
+		sync.Mutex
+		// Leader of every datacenter seen in the previous scrape
+		lastLeader       map[string]string
+		leaderChanges    *prometheus.CounterVec
+		lastLeaderChange *prometheus.GaugeVec
+	}
+)
+
+// Error of queries which need a leader while the cluster has none.
+func isNoLeader(err error) bool {
+	return strings.Contains(err.Error(), "No cluster leader")
+}
+
+// Query the Raft configuration of a datacenter. Unlike the status endpoints
+// this can be forwarded to other datacenters, the leader is the server
+// flagged as such. The configuration is nil if the query failed.
+func (c *consulRaftCollector) raftState(datacenter string) *raftState {
+	st := &raftState{datacenter: datacenter}
+	q := c.client.queryOptions()
+	q.Datacenter = datacenter
+	// Let any server answer, in the default mode the query fails exactly
+	// when the leader is lost
+	q.AllowStale = true
+	q.RequireConsistent = false
+	config, err := c.client.Operator().RaftGetConfiguration(q)
+	if err != nil {
+		queryFailed(datacenter, "Operator().RaftGetConfiguration()", err)
+		st.known = isNoLeader(err)
+		return st
+	}
+	st.known = true
+	st.config = config
+	for _, s := range config.Servers {
+		if s.Leader {
+			st.leader = s.Address
+		}
+	}
+	return st
+}
+
+func NewConsulRaftCollector(uri string, httpClient *HttpClient) (prometheus.Collector, error) {
+	client, err := newConsulClient(uri, httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	leaderChanges := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: consulNameSpace,
+		Subsystem: "raft",
+		Name:      "leader_changes_total",
+		Help:      "Number of leader changes observed by the exporter, including losing the leader.",
+	}, []string{"datacenter"})
+	lastLeaderChange := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: consulNameSpace,
+		Subsystem: "raft",
+		Name:      "last_leader_change_timestamp_seconds",
+		Help:      "Time of the last leader change observed by the exporter in seconds since epoch.",
+	}, []string{"datacenter"})
+
+	metrics := map[prometheus.Collector]func([]*raftState, prometheus.Collector){
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: consulNameSpace,
+			Subsystem: "raft",
+			Name:      "leader",
+			Help:      "1 if the cluster has a leader, 0 if not.",
+		}, []string{"datacenter"}): func(sts []*raftState, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, st := range sts {
+				if !st.known {
+					continue
+				}
+				hasLeader := 0.0
+				if st.leader != "" {
+					hasLeader = 1
+				}
+				c.(*prometheus.GaugeVec).WithLabelValues(st.datacenter).Set(hasLeader)
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: consulNameSpace,
+			Subsystem: "raft",
+			Name:      "leader_info",
+			Help:      "Address of the current leader, always 1.",
+		}, []string{"datacenter", "address"}): func(sts []*raftState, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, st := range sts {
+				if st.leader != "" {
+					c.(*prometheus.GaugeVec).WithLabelValues(st.datacenter, st.leader).Set(1)
+				}
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: consulNameSpace,
+			Subsystem: "raft",
+			Name:      "peers",
+			Help:      "Number of Raft peers in the cluster.",
+		}, []string{"datacenter"}): func(sts []*raftState, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, st := range sts {
+				if st.config != nil {
+					c.(*prometheus.GaugeVec).WithLabelValues(st.datacenter).Set(float64(len(st.config.Servers)))
+				}
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: consulNameSpace,
+			Subsystem: "raft",
+			Name:      "server_voter",
+			Help:      "1 if the server has a vote in the Raft configuration, 0 if not.",
+		}, []string{"datacenter", "node", "id", "address"}): func(sts []*raftState, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, st := range sts {
+				if st.config == nil {
+					continue
+				}
+				for _, s := range st.config.Servers {
+					voter := 0.0
+					if s.Voter {
+						voter = 1
+					}
+					c.(*prometheus.GaugeVec).WithLabelValues(st.datacenter, s.Node, s.ID, s.Address).Set(voter)
+				}
+			}
+		},
+	}
+
+	return &consulRaftCollector{
+		client:           client,
+		metrics:          metrics,
+		lastLeader:       map[string]string{},
+		leaderChanges:    leaderChanges,
+		lastLeaderChange: lastLeaderChange,
+	}, nil
+}
+
+// Remember the leader of the datacenter and return whether it changed since
+// the previous scrape, including losing the leader. A failed query says
+// nothing about the leader.
+func (c *consulRaftCollector) leaderChanged(st *raftState) bool {
+	if !st.known {
+		return false
+	}
+	last, ok := c.lastLeader[st.datacenter]
+	c.lastLeader[st.datacenter] = st.leader
+	return ok && last != st.leader
+}
+
+func (c *consulRaftCollector) Collect(ch chan<- prometheus.Metric) {
+	c.Lock()
+	defer c.Unlock()
+
+	sts := []*raftState{}
+	for _, dc := range c.client.datacenters() {
+		st := c.raftState(dc)
+		c.leaderChanges.WithLabelValues(dc).Add(0)
+		sts = append(sts, st)
+		if c.leaderChanged(st) {
+			c.leaderChanges.WithLabelValues(dc).Inc()
+			c.lastLeaderChange.WithLabelValues(dc).Set(float64(time.Now().Unix()))
+		}
+	}
+	c.leaderChanges.Collect(ch)
+	c.lastLeaderChange.Collect(ch)
+
+	for c, set := range c.metrics {
+		set(sts, c)
+		c.Collect(ch)
+	}
+}
+
+func (c *consulRaftCollector) Describe(ch chan<- *prometheus.Desc) {
+	c.leaderChanges.Describe(ch)
+	c.lastLeaderChange.Describe(ch)
+	for metric := range c.metrics {
+		metric.Describe(ch)
+	}
+}
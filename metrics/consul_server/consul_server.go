@@ -6,10 +6,13 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	consul_api "github.com/hashicorp/consul/api"
-	"github.com/needkane/n-exporter/common"
 	"github.com/prometheus/client_golang/prometheus.v2"
 )
 
@@ -18,24 +21,90 @@ type AuthInfo struct {
 	Password string
 }
 
+// Options applied to every Consul query. The token and datacenter default
+// to those of the agent, reads to the default consistency mode.
+type QueryConfig struct {
+	Token             string
+	Datacenter        string
+	AllowStale        bool
+	RequireConsistent bool
+	// Datacenters whose catalog, health and Raft state is scraped through
+	// the local servers. Empty scrapes Datacenter only, AllDatacenters every
+	// datacenter known to the servers.
+	Datacenters []string
+}
+
+const AllDatacenters = "*"
+
 type HttpClient struct {
 	http.Client
-	Url  string
-	Auth AuthInfo
+	Url   string
+	Auth  AuthInfo
+	Query QueryConfig
 }
-type metricMap map[string]float64
 type (
+	// Consul API client remembering the consistency mode for its queries
+	consulClient struct {
+		*consul_api.Client
+		query QueryConfig
+
+		sync.Mutex
+		local string
+		// Earliest time to ask the agent for the local datacenter again
+		// after it failed to answer
+		localRetry time.Time
+		// Datacenters known to the servers as of the last successful query
+		known []string
+	}
+
+	catalog struct {
+		datacenter string
+		services   map[string][]string
+		instances  map[string][]*consul_api.CatalogService
+		nodes      []*consul_api.Node
+	}
+
 	consulServerCollector struct {
-		*HttpClient
-		client  *consul_api.Client
-		metrics map[prometheus.Collector]func(metricMap, prometheus.Collector) error
+		views *Views
+		// Every watched service takes a connection to the Consul agent
+		serviceWatchLimit int
+		metrics           map[prometheus.Collector]func([]*catalog, prometheus.Collector)
+
+		sync.Mutex
+		limitLogged bool
 	}
 )
 
 var consulNameSpace = "consul"
-var catalog_services_num = "catalog_services_num"
 
-func NewConsulServerCollector(uri string, httpClient *HttpClient) (prometheus.Collector, error) {
+// QueryErrors counts failed Consul queries by datacenter and reason. Queries
+// denied by the ACL system are reported separately from other errors, as
+// they won't go away without changing the token.
+var QueryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: consulNameSpace,
+	Name:      "query_errors_total",
+	Help:      "Number of failed Consul queries by datacenter and reason.",
+}, []string{"datacenter", "query", "reason"})
+
+func isACLDenied(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "response code: 403") ||
+		strings.Contains(msg, "Permission denied") ||
+		strings.Contains(msg, "ACL not found")
+}
+
+// Log and count a failed query.
+func queryFailed(datacenter, query string, err error) {
+	if isACLDenied(err) {
+		log.Printf("%s in datacenter %q denied by ACL, check the token: %s", query, datacenter, err)
+		QueryErrors.WithLabelValues(datacenter, query, "acl_denied").Inc()
+		return
+	}
+	log.Printf("%s in datacenter %q failed: %s", query, datacenter, err)
+	QueryErrors.WithLabelValues(datacenter, query, "error").Inc()
+}
+
+func newConsulClient(uri string, httpClient *HttpClient) (*consulClient, error) {
 	u, err := url.Parse(uri)
 	if err != nil {
 		return nil, fmt.Errorf("invalid consul URL: %s", err)
@@ -47,42 +116,227 @@ func NewConsulServerCollector(uri string, httpClient *HttpClient) (prometheus.Co
 	config := consul_api.DefaultConfig()
 	config.Address = u.Host
 	config.Scheme = u.Scheme
+	// Use the exporter's timeout and TLS settings instead of the defaults
+	config.HttpClient = &httpClient.Client
+	// Token and datacenter set here are sent with every request
+	if httpClient.Query.Token != "" {
+		config.Token = httpClient.Query.Token
+	}
+	config.Datacenter = httpClient.Query.Datacenter
+	if httpClient.Auth.Username != "" {
+		config.HttpAuth = &consul_api.HttpBasicAuth{
+			Username: httpClient.Auth.Username,
+			Password: httpClient.Auth.Password,
+		}
+	}
 
 	client, err := consul_api.NewClient(config)
 	if err != nil {
 		return nil, err
+	}
+	return &consulClient{Client: client, query: httpClient.Query}, nil
+}
 
+// Name of the datacenter queries go to by default, empty while the agent
+// can't be asked for it. A failed query isn't retried before
+// watchRetryInterval has passed.
+func (c *consulClient) localDatacenter() string {
+	if c.query.Datacenter != "" {
+		return c.query.Datacenter
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.local == "" && time.Now().After(c.localRetry) {
+		self, err := c.Agent().Self()
+		if err != nil {
+			queryFailed("", "Agent().Self()", err)
+			c.localRetry = time.Now().Add(watchRetryInterval)
+			return ""
+		}
+		c.local, _ = self["Config"]["Datacenter"].(string)
 	}
+	return c.local
+}
+
+// Datacenters to scrape catalog, health and Raft state of. If the servers
+// can't be asked for all of them, the ones they knew last time are used. An
+// empty name is the datacenter queries go to by default, used while its name
+// isn't known.
+func (c *consulClient) datacenters() []string {
+	switch {
+	case len(c.query.Datacenters) == 1 && c.query.Datacenters[0] == AllDatacenters:
+		dcs, err := c.Catalog().Datacenters()
+		if err != nil {
+			queryFailed(c.localDatacenter(), "Catalog().Datacenters()", err)
+		}
+		c.Lock()
+		defer c.Unlock()
+		if err == nil {
+			c.known = dcs
+		}
+		return c.known
+	case len(c.query.Datacenters) > 0:
+		return c.query.Datacenters
+	default:
+		return []string{c.localDatacenter()}
+	}
+}
 
-	metrics := map[prometheus.Collector]func(metricMap, prometheus.Collector) error{
-		common.CustomCounter(consulNameSpace, catalog_services_num, "How many services are in the cluster.", "catalog_services_num"): func(m metricMap, c prometheus.Collector) error {
-			servicesNum, ok := m[catalog_services_num]
-			if !ok {
-				return fmt.Errorf("notFoundInMap")
+// Options for a read in the configured consistency mode
+func (c *consulClient) queryOptions() *consul_api.QueryOptions {
+	return &consul_api.QueryOptions{
+		AllowStale:        c.query.AllowStale,
+		RequireConsistent: c.query.RequireConsistent,
+	}
+}
+
+// Return the whitelisted tags of a service, sorted and comma-separated.
+func whitelistedTags(tags []string, whitelist []string) string {
+	found := []string{}
+	for _, t := range tags {
+		for _, w := range whitelist {
+			if t == w {
+				found = append(found, t)
+				break
+			}
+		}
+	}
+	sort.Strings(found)
+	return strings.Join(found, ",")
+}
+
+// NewConsulServerCollector returns a collector for the catalog of the
+// scraped datacenters. The instances of at most serviceWatchLimit services
+// are watched for the per-service metrics, in order of datacenter and name.
+func NewConsulServerCollector(views *Views, tagWhitelist []string, serviceWatchLimit int) prometheus.Collector {
+	metrics := map[prometheus.Collector]func([]*catalog, prometheus.Collector){
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: consulNameSpace,
+			Name:      "catalog_services_num",
+			Help:      "How many services are in the cluster.",
+		}, []string{"datacenter"}): func(cats []*catalog, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, cat := range cats {
+				c.(*prometheus.GaugeVec).WithLabelValues(cat.datacenter).Set(float64(len(cat.services)))
 			}
-			log.Println("consul_server.go 63------", servicesNum)
-			c.(*common.SettableCounterVec).SetGauge(servicesNum, "servicesNum")
-			return nil
 		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: consulNameSpace,
+			Subsystem: "catalog",
+			Name:      "nodes",
+			Help:      "How many nodes are in the cluster.",
+		}, []string{"datacenter"}): func(cats []*catalog, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, cat := range cats {
+				if cat.nodes != nil {
+					c.(*prometheus.GaugeVec).WithLabelValues(cat.datacenter).Set(float64(len(cat.nodes)))
+				}
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: consulNameSpace,
+			Subsystem: "catalog",
+			Name:      "service_instances",
+			Help:      "Number of registered instances per service.",
+		}, []string{"datacenter", "service"}): func(cats []*catalog, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, cat := range cats {
+				for name, instances := range cat.instances {
+					c.(*prometheus.GaugeVec).WithLabelValues(cat.datacenter, name).Set(float64(len(instances)))
+				}
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: consulNameSpace,
+			Subsystem: "catalog",
+			Name:      "service_tag_instances",
+			Help:      "Number of registered instances per service and tag.",
+		}, []string{"datacenter", "service", "tag"}): func(cats []*catalog, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, cat := range cats {
+				for name, instances := range cat.instances {
+					for _, instance := range instances {
+						for _, tag := range instance.ServiceTags {
+							c.(*prometheus.GaugeVec).WithLabelValues(cat.datacenter, name, tag).Inc()
+						}
+					}
+				}
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: consulNameSpace,
+			Subsystem: "catalog",
+			Name:      "service_info",
+			Help:      "Service with its whitelisted tags, always 1.",
+		}, []string{"datacenter", "service", "tags"}): func(cats []*catalog, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, cat := range cats {
+				for name, tags := range cat.services {
+					c.(*prometheus.GaugeVec).WithLabelValues(cat.datacenter, name, whitelistedTags(tags, tagWhitelist)).Set(1)
+				}
+			}
+		},
+	}
+
+	// Start watching right away so the first scrape has data
+	for _, dc := range views.datacenters() {
+		views.services(dc)
+		views.nodes(dc)
 	}
 
 	return &consulServerCollector{
-		HttpClient: httpClient,
-		metrics:    metrics,
-		client:     client,
-	}, nil
+		views:             views,
+		serviceWatchLimit: serviceWatchLimit,
+		metrics:           metrics,
+	}
 }
 
 func (c *consulServerCollector) Collect(ch chan<- prometheus.Metric) {
-	//c.fetchAndDecode("/v1/catalog/services", &s)
-	// Query for the full list of services.
-	serviceNames, _, err := c.client.Catalog().Services(&consul_api.QueryOptions{})
-	if err != nil {
-		fmt.Errorf("Catalog().Services() failed")
+	c.Lock()
+	defer c.Unlock()
+
+	cats := []*catalog{}
+	remaining := c.serviceWatchLimit
+	for _, dc := range c.views.datacenters() {
+		services, ok := c.views.services(dc)
+		if !ok {
+			continue
+		}
+		cat := &catalog{
+			datacenter: dc,
+			services:   services,
+			instances:  map[string][]*consul_api.CatalogService{},
+		}
+		names := make([]string, 0, len(services))
+		for name := range services {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		watched := map[string]bool{}
+		for _, name := range names {
+			if remaining <= 0 {
+				if !c.limitLogged {
+					log.Printf("Watching the instances of %d Consul services only, raise -consulServiceWatchLimit to watch more", c.serviceWatchLimit)
+					c.limitLogged = true
+				}
+				break
+			}
+			remaining--
+			watched["catalog/service/"+name] = true
+			if instances, ok := c.views.service(dc, name); ok {
+				cat.instances[name] = instances
+			}
+		}
+		// Stop watching services that have been deregistered or are over
+		// the limit
+		c.views.prune(dc, "catalog/service/", watched)
+		cat.nodes, _ = c.views.nodes(dc)
+		cats = append(cats, cat)
 	}
-	m := metricMap{catalog_services_num: float64(len(serviceNames))}
+
 	for c, set := range c.metrics {
-		set(m, c)
+		set(cats, c)
 		c.Collect(ch)
 	}
 }
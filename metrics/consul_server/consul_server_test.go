@@ -0,0 +1,196 @@
+package consul_server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus.v2"
+)
+
+func TestWhitelistedTags(t *testing.T) {
+	for i, tt := range []struct {
+		tags, whitelist []string
+		want            string
+	}{
+		{nil, nil, ""},
+		{[]string{"http", "v2"}, nil, ""},
+		{[]string{"v2", "http", "internal"}, []string{"http", "v2"}, "http,v2"},
+		{[]string{"internal"}, []string{"http", "v2"}, ""},
+	} {
+		if got := whitelistedTags(tt.tags, tt.whitelist); got != tt.want {
+			t.Errorf("test #%d: got: %q, want: %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestQueryFailed(t *testing.T) {
+	for i, tt := range []struct {
+		err    string
+		reason string
+	}{
+		{"Unexpected response code: 403 (Permission denied)", "acl_denied"},
+		{"Unexpected response code: 403 (ACL not found)", "acl_denied"},
+		{"rpc error: Permission denied", "acl_denied"},
+		{"Unexpected response code: 500 (No cluster leader)", "error"},
+		{"dial tcp 127.0.0.1:8500: connection refused", "error"},
+	} {
+		queryFailed("dc1", "Health().State()", errors.New(tt.err))
+		for _, reason := range []string{"acl_denied", "error"} {
+			// Only the error count of the expected reason is left to delete
+			if deleted := QueryErrors.DeleteLabelValues("dc1", "Health().State()", reason); deleted != (reason == tt.reason) {
+				t.Errorf("test #%d: %s count present: %v, want: %v", i, reason, deleted, reason == tt.reason)
+			}
+		}
+	}
+}
+
+func TestViews_Datacenters(t *testing.T) {
+	v := &Views{
+		client:  &consulClient{query: QueryConfig{Datacenters: []string{"dc1"}}},
+		watches: map[string]*watch{},
+		errors:  prometheus.NewCounterVec(prometheus.CounterOpts{Name: "errors_total", Help: "Errors."}, []string{"datacenter", "view"}),
+	}
+	for _, key := range [][2]string{
+		{"dc1", "catalog/nodes"},
+		{"dc2", "catalog/nodes"},
+		{"", "catalog/nodes"},
+		{"", "kv/config/"},
+	} {
+		v.watches[key[0]+"/"+key[1]] = &watch{
+			datacenter: key[0],
+			name:       key[1],
+			errors:     v.errors.WithLabelValues(key[0], key[1]),
+			stop:       make(chan struct{}),
+		}
+	}
+
+	if got, want := v.datacenters(), []string{"dc1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+	got := []string{}
+	for key := range v.watches {
+		got = append(got, key)
+	}
+	sort.Strings(got)
+	if want := []string{"/kv/config/", "dc1/catalog/nodes"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+}
+
+func TestViews_Prune(t *testing.T) {
+	for i, tt := range []struct {
+		datacenter, prefix string
+		keep               map[string]bool
+		want               []string
+	}{
+		{"dc1", "catalog/service/", map[string]bool{"catalog/service/web": true}, []string{"dc1/catalog/nodes", "dc1/catalog/service/web", "dc2/catalog/service/db"}},
+		{"dc1", "catalog/service/", map[string]bool{}, []string{"dc1/catalog/nodes", "dc2/catalog/service/db"}},
+		{"dc3", "catalog/service/", map[string]bool{}, []string{"dc1/catalog/nodes", "dc1/catalog/service/db", "dc1/catalog/service/web", "dc2/catalog/service/db"}},
+	} {
+		v := &Views{
+			watches: map[string]*watch{},
+			errors:  prometheus.NewCounterVec(prometheus.CounterOpts{Name: "errors_total", Help: "Errors."}, []string{"datacenter", "view"}),
+		}
+		for _, key := range [][2]string{
+			{"dc1", "catalog/nodes"},
+			{"dc1", "catalog/service/web"},
+			{"dc1", "catalog/service/db"},
+			{"dc2", "catalog/service/db"},
+		} {
+			v.watches[key[0]+"/"+key[1]] = &watch{
+				datacenter: key[0],
+				name:       key[1],
+				errors:     v.errors.WithLabelValues(key[0], key[1]),
+				stop:       make(chan struct{}),
+			}
+		}
+		all := map[string]*watch{}
+		for key, w := range v.watches {
+			all[key] = w
+		}
+
+		v.prune(tt.datacenter, tt.prefix, tt.keep)
+
+		got := []string{}
+		for key := range v.watches {
+			got = append(got, key)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("test #%d: got: %v, want: %v", i, got, tt.want)
+		}
+		for key, w := range all {
+			_, kept := v.watches[key]
+			select {
+			case <-w.stop:
+				if kept {
+					t.Errorf("test #%d: watch %s kept but stopped", i, key)
+				}
+			default:
+				if !kept {
+					t.Errorf("test #%d: watch %s pruned but not stopped", i, key)
+				}
+			}
+			// Only the error counts of kept watches are left to delete
+			if deleted := v.errors.DeleteLabelValues(w.datacenter, w.name); deleted != kept {
+				t.Errorf("test #%d: watch %s error count present: %v, want: %v", i, key, deleted, kept)
+			}
+		}
+	}
+}
+
+func TestConsulRaftCollector_LeaderChanges(t *testing.T) {
+	const withLeader = `{"Servers": [{"ID": "a", "Node": "a", "Address": "10.0.0.1:8300", "Leader": true, "Voter": true}], "Index": 1}`
+	const withoutLeader = `{"Servers": [{"ID": "a", "Node": "a", "Address": "10.0.0.1:8300", "Leader": false, "Voter": true}], "Index": 1}`
+	type response struct {
+		code int
+		body string
+	}
+	for i, tt := range []struct {
+		responses []response
+		leader    string
+		known     bool
+		changes   int
+	}{
+		{[]response{{200, withLeader}}, "10.0.0.1:8300", true, 0},
+		{[]response{{200, withLeader}, {200, withoutLeader}}, "", true, 1},
+		// Servers refusing to answer without a leader mean there is none
+		{[]response{{200, withLeader}, {500, "No cluster leader"}}, "", true, 1},
+		{[]response{{500, "No cluster leader"}, {200, withLeader}}, "10.0.0.1:8300", true, 1},
+		// Other errors say nothing about the leader
+		{[]response{{200, withLeader}, {500, "rpc error"}, {200, withLeader}}, "10.0.0.1:8300", true, 0},
+		{[]response{{200, withLeader}, {403, "Permission denied"}}, "", false, 0},
+	} {
+		var next response
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Remote datacenters are queried through the local servers
+			if _, ok := r.URL.Query()["stale"]; !ok || r.URL.Query().Get("dc") != "dc2" {
+				t.Errorf("test #%d: unexpected query: %s", i, r.URL)
+			}
+			w.WriteHeader(next.code)
+			w.Write([]byte(next.body))
+		}))
+		client, err := newConsulClient(ts.URL, &HttpClient{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &consulRaftCollector{client: client, lastLeader: map[string]string{}}
+
+		changes := 0
+		var st *raftState
+		for _, next = range tt.responses {
+			st = c.raftState("dc2")
+			if c.leaderChanged(st) {
+				changes++
+			}
+		}
+		ts.Close()
+		if st.leader != tt.leader || st.known != tt.known || changes != tt.changes {
+			t.Errorf("test #%d: got: %q, %v, %d changes, want: %q, %v, %d changes", i, st.leader, st.known, changes, tt.leader, tt.known, tt.changes)
+		}
+	}
+}
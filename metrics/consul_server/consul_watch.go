@@ -0,0 +1,300 @@
+package consul_server
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	consul_api "github.com/hashicorp/consul/api"
+	"github.com/prometheus/client_golang/prometheus.v2"
+)
+
+const (
+	// Upper bound of a single blocking query
+	watchWaitTime = 5 * time.Minute
+	// Pause between retries after a failed query
+	watchRetryInterval = 10 * time.Second
+)
+
+type (
+	// A blocking query returning the new value of a view
+	queryFunc func(q *consul_api.QueryOptions) (interface{}, *consul_api.QueryMeta, error)
+
+	// A view kept up to date by a blocking query running in the background
+	watch struct {
+		datacenter string
+		name       string
+		query      queryFunc
+		options    func() *consul_api.QueryOptions
+		errors     prometheus.Counter
+		stop       chan struct{}
+
+		sync.Mutex
+		value       interface{}
+		lastIndex   uint64
+		lastContact time.Duration
+		lastUpdate  time.Time
+		failing     bool
+	}
+
+	// Views keeps catalog, health and KV data of a Consul cluster in memory,
+	// so scrapes don't have to query the Consul servers.
+	Views struct {
+		client *consulClient
+
+		sync.Mutex
+		watches map[string]*watch
+
+		errors  *prometheus.CounterVec
+		metrics map[prometheus.Collector]func([]*watch, prometheus.Collector)
+	}
+)
+
+func (w *watch) run() {
+	var index uint64
+	for {
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+
+		q := w.options()
+		q.Datacenter = w.datacenter
+		q.WaitIndex = index
+		q.WaitTime = watchWaitTime
+		value, meta, err := w.query(q)
+		if err != nil {
+			queryFailed(w.datacenter, "watch "+w.name, err)
+			w.errors.Inc()
+			w.Lock()
+			w.failing = true
+			w.Unlock()
+			select {
+			case <-w.stop:
+				return
+			case <-time.After(watchRetryInterval):
+			}
+			continue
+		}
+		// The index may go backwards after a snapshot restore, start over
+		// in that case instead of blocking until it catches up again. An
+		// index of 0 wouldn't block at all, wait for any change instead.
+		switch {
+		case meta.LastIndex == 0:
+			index = 1
+		case meta.LastIndex < index:
+			index = 0
+		default:
+			index = meta.LastIndex
+		}
+
+		w.Lock()
+		w.value = value
+		w.lastIndex = meta.LastIndex
+		w.lastContact = meta.LastContact
+		w.lastUpdate = time.Now()
+		w.failing = false
+		w.Unlock()
+	}
+}
+
+// Current value of the view, nil until the first query succeeded.
+func (w *watch) get() (interface{}, uint64) {
+	w.Lock()
+	defer w.Unlock()
+	return w.value, w.lastIndex
+}
+
+// How far the view is behind the Consul leader. While the query succeeds
+// this is the staleness reported by the server, otherwise it grows with the
+// time since the last successful query.
+func (w *watch) lag() float64 {
+	w.Lock()
+	defer w.Unlock()
+	lag := w.lastContact
+	if w.failing {
+		lag += time.Since(w.lastUpdate)
+	}
+	return lag.Seconds()
+}
+
+func NewViews(uri string, httpClient *HttpClient) (*Views, error) {
+	// Blocking queries outlast the scrape timeout, the server may hold them
+	// for the wait time plus up to a sixteenth of jitter.
+	watchClient := *httpClient
+	if watchClient.Timeout != 0 {
+		watchClient.Timeout += watchWaitTime + watchWaitTime/16
+	}
+	client, err := newConsulClient(uri, &watchClient)
+	if err != nil {
+		return nil, err
+	}
+	// Resolved up front so the first watches are keyed by the datacenter's
+	// name instead of being restarted once it's known
+	client.localDatacenter()
+
+	metrics := map[prometheus.Collector]func([]*watch, prometheus.Collector){
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: consulNameSpace,
+			Subsystem: "watch",
+			Name:      "lag_seconds",
+			Help:      "How far the in-memory view is behind the Consul leader.",
+		}, []string{"datacenter", "view"}): func(ws []*watch, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, w := range ws {
+				if value, _ := w.get(); value != nil {
+					c.(*prometheus.GaugeVec).WithLabelValues(w.datacenter, w.name).Set(w.lag())
+				}
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: consulNameSpace,
+			Subsystem: "watch",
+			Name:      "index",
+			Help:      "Raft index of the in-memory view.",
+		}, []string{"datacenter", "view"}): func(ws []*watch, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, w := range ws {
+				if value, index := w.get(); value != nil {
+					c.(*prometheus.GaugeVec).WithLabelValues(w.datacenter, w.name).Set(float64(index))
+				}
+			}
+		},
+	}
+
+	return &Views{
+		client:  client,
+		watches: map[string]*watch{},
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: consulNameSpace,
+			Subsystem: "watch",
+			Name:      "errors_total",
+			Help:      "Number of failed blocking queries.",
+		}, []string{"datacenter", "view"}),
+		metrics: metrics,
+	}, nil
+}
+
+// Return the watch of the given datacenter and name, starting it if it isn't
+// running yet. An empty datacenter is the one queries go to by default.
+func (v *Views) watch(datacenter, name string, query queryFunc) *watch {
+	v.Lock()
+	defer v.Unlock()
+	key := datacenter + "/" + name
+	if w, ok := v.watches[key]; ok {
+		return w
+	}
+	w := &watch{
+		datacenter: datacenter,
+		name:       name,
+		query:      query,
+		options:    v.client.queryOptions,
+		errors:     v.errors.WithLabelValues(datacenter, name),
+		stop:       make(chan struct{}),
+	}
+	v.watches[key] = w
+	go w.run()
+	return w
+}
+
+// Stop the watches of the datacenter with the given name prefix that aren't
+// in keep.
+func (v *Views) prune(datacenter, prefix string, keep map[string]bool) {
+	v.Lock()
+	defer v.Unlock()
+	for key, w := range v.watches {
+		if w.datacenter == datacenter && strings.HasPrefix(w.name, prefix) && !keep[w.name] {
+			v.stop(key, w)
+		}
+	}
+}
+
+// Stop a watch and forget its error count. The lock must be held.
+func (v *Views) stop(key string, w *watch) {
+	close(w.stop)
+	delete(v.watches, key)
+	v.errors.DeleteLabelValues(w.datacenter, w.name)
+}
+
+// Datacenters to keep views of. Watches of datacenters that are gone are
+// stopped, KV watches always go to the default datacenter and are left
+// alone.
+func (v *Views) datacenters() []string {
+	dcs := v.client.datacenters()
+	keep := map[string]bool{}
+	for _, dc := range dcs {
+		keep[dc] = true
+	}
+
+	v.Lock()
+	defer v.Unlock()
+	for key, w := range v.watches {
+		if !keep[w.datacenter] && !strings.HasPrefix(w.name, "kv/") {
+			v.stop(key, w)
+		}
+	}
+	return dcs
+}
+
+func (v *Views) services(datacenter string) (map[string][]string, bool) {
+	value, _ := v.watch(datacenter, "catalog/services", func(q *consul_api.QueryOptions) (interface{}, *consul_api.QueryMeta, error) {
+		return v.client.Catalog().Services(q)
+	}).get()
+	services, ok := value.(map[string][]string)
+	return services, ok
+}
+
+func (v *Views) service(datacenter, name string) ([]*consul_api.CatalogService, bool) {
+	value, _ := v.watch(datacenter, "catalog/service/"+name, func(q *consul_api.QueryOptions) (interface{}, *consul_api.QueryMeta, error) {
+		return v.client.Catalog().Service(name, "", q)
+	}).get()
+	instances, ok := value.([]*consul_api.CatalogService)
+	return instances, ok
+}
+
+func (v *Views) nodes(datacenter string) ([]*consul_api.Node, bool) {
+	value, _ := v.watch(datacenter, "catalog/nodes", func(q *consul_api.QueryOptions) (interface{}, *consul_api.QueryMeta, error) {
+		return v.client.Catalog().Nodes(q)
+	}).get()
+	nodes, ok := value.([]*consul_api.Node)
+	return nodes, ok
+}
+
+func (v *Views) checks(datacenter string) (consul_api.HealthChecks, bool) {
+	value, _ := v.watch(datacenter, "health/state", func(q *consul_api.QueryOptions) (interface{}, *consul_api.QueryMeta, error) {
+		return v.client.Health().State(consul_api.HealthAny, q)
+	}).get()
+	checks, ok := value.(consul_api.HealthChecks)
+	return checks, ok
+}
+
+func (v *Views) kv(prefix string) (consul_api.KVPairs, bool) {
+	value, _ := v.watch("", "kv/"+prefix, func(q *consul_api.QueryOptions) (interface{}, *consul_api.QueryMeta, error) {
+		return v.client.KV().List(prefix, q)
+	}).get()
+	pairs, ok := value.(consul_api.KVPairs)
+	return pairs, ok
+}
+
+func (v *Views) Collect(ch chan<- prometheus.Metric) {
+	v.Lock()
+	ws := make([]*watch, 0, len(v.watches))
+	for _, w := range v.watches {
+		ws = append(ws, w)
+	}
+	v.Unlock()
+
+	for c, set := range v.metrics {
+		set(ws, c)
+		c.Collect(ch)
+	}
+	v.errors.Collect(ch)
+}
+
+func (v *Views) Describe(ch chan<- *prometheus.Desc) {
+	for metric := range v.metrics {
+		metric.Describe(ch)
+	}
+	v.errors.Describe(ch)
+}
@@ -0,0 +1,203 @@
+// Scrape the DC/OS overlay network module endpoints. Information scraped at
+// this point:
+//
+// * Agent subnets allocated from each overlay (master, /overlay-master/state)
+// * Overlay registration status per agent (master and agent, /overlay-agent/overlay)
+// * Recovery state and failure counters of the overlay module (/metrics/snapshot)
+package main
+
+import (
+	"math"
+	"net"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus.v2"
+)
+
+type (
+	overlayInfo struct {
+		Name   string `json:"name"`
+		Subnet string `json:"subnet"`
+		Prefix int    `json:"prefix"`
+	}
+
+	agentOverlay struct {
+		Info   overlayInfo `json:"info"`
+		Subnet string      `json:"subnet"`
+		State  struct {
+			Status string `json:"status"`
+		} `json:"state"`
+	}
+
+	overlayAgent struct {
+		IP       string         `json:"ip"`
+		Overlays []agentOverlay `json:"overlays"`
+	}
+
+	overlayMasterState struct {
+		Network struct {
+			Overlays []overlayInfo `json:"overlays"`
+		} `json:"network"`
+		Agents []overlayAgent `json:"agents"`
+	}
+
+	overlayMasterCollector struct {
+		*httpClient
+		metrics map[prometheus.Collector]func(*overlayMasterState, prometheus.Collector)
+	}
+
+	overlayAgentCollector struct {
+		*httpClient
+		metrics map[prometheus.Collector]func(*overlayAgent, prometheus.Collector)
+	}
+)
+
+// Return the number of agent subnets the overlay can be split into. ok is
+// false for unparseable overlays.
+func (o *overlayInfo) capacity() (subnets float64, ok bool) {
+	_, ipNet, err := net.ParseCIDR(o.Subnet)
+	if err != nil {
+		return 0, false
+	}
+	ones, bits := ipNet.Mask.Size()
+	if o.Prefix < ones || o.Prefix > bits {
+		return 0, false
+	}
+	return math.Pow(2, float64(o.Prefix-ones)), true
+}
+
+// Return the number of agent subnets allocated from each overlay.
+func (st *overlayMasterState) allocatedSubnets() map[string]float64 {
+	allocated := map[string]float64{}
+	for _, a := range st.Agents {
+		for _, o := range a.Overlays {
+			allocated[o.Info.Name]++
+		}
+	}
+	return allocated
+}
+
+func overlayStatus(status string) string {
+	return strings.ToLower(strings.TrimPrefix(status, "STATUS_"))
+}
+
+func newOverlayMasterCollector(httpClient *httpClient) prometheus.Collector {
+	metrics := map[prometheus.Collector]func(*overlayMasterState, prometheus.Collector){
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "Number of agent subnets of the overlay by allocation state",
+			Namespace: "mesos",
+			Subsystem: "overlay",
+			Name:      "subnets",
+		}, []string{"overlay", "type"}): func(st *overlayMasterState, c prometheus.Collector) {
+			allocated := st.allocatedSubnets()
+			c.(*prometheus.GaugeVec).Reset()
+			for _, o := range st.Network.Overlays {
+				subnets, ok := o.capacity()
+				if !ok {
+					continue
+				}
+				c.(*prometheus.GaugeVec).WithLabelValues(o.Name, "allocated").Set(allocated[o.Name])
+				c.(*prometheus.GaugeVec).WithLabelValues(o.Name, "free").Set(subnets - allocated[o.Name])
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "1 if the agent's overlay is in the given status, as seen by the master",
+			Namespace: "mesos",
+			Subsystem: "overlay",
+			Name:      "agent_status",
+		}, []string{"agent", "overlay", "subnet", "status"}): func(st *overlayMasterState, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, a := range st.Agents {
+				for _, o := range a.Overlays {
+					c.(*prometheus.GaugeVec).WithLabelValues(a.IP, o.Info.Name, o.Subnet, overlayStatus(o.State.Status)).Set(1)
+				}
+			}
+		},
+	}
+
+	return &overlayMasterCollector{httpClient, metrics}
+}
+
+func (c *overlayMasterCollector) Collect(ch chan<- prometheus.Metric) {
+	var st overlayMasterState
+	c.fetchAndDecode("/overlay-master/state", &st)
+	for c, set := range c.metrics {
+		set(&st, c)
+		c.Collect(ch)
+	}
+}
+
+func (c *overlayMasterCollector) Describe(ch chan<- *prometheus.Desc) {
+	for metric := range c.metrics {
+		metric.Describe(ch)
+	}
+}
+
+func newOverlayAgentCollector(httpClient *httpClient) prometheus.Collector {
+	metrics := map[prometheus.Collector]func(*overlayAgent, prometheus.Collector){
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "1 if the overlay is in the given registration status on this agent",
+			Namespace: "mesos",
+			Subsystem: "overlay",
+			Name:      "registration_status",
+		}, []string{"overlay", "subnet", "status"}): func(a *overlayAgent, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, o := range a.Overlays {
+				c.(*prometheus.GaugeVec).WithLabelValues(o.Info.Name, o.Subnet, overlayStatus(o.State.Status)).Set(1)
+			}
+		},
+	}
+
+	return &overlayAgentCollector{httpClient, metrics}
+}
+
+func (c *overlayAgentCollector) Collect(ch chan<- prometheus.Metric) {
+	var a overlayAgent
+	c.fetchAndDecode("/overlay-agent/overlay", &a)
+	for c, set := range c.metrics {
+		set(&a, c)
+		c.Collect(ch)
+	}
+}
+
+func (c *overlayAgentCollector) Describe(ch chan<- *prometheus.Desc) {
+	for metric := range c.metrics {
+		metric.Describe(ch)
+	}
+}
+
+// Metrics the overlay module publishes in the master's /metrics/snapshot.
+// Keys missing in older module versions are skipped.
+func newOverlayMasterMetricCollector(httpClient *httpClient) prometheus.Collector {
+	metrics := map[prometheus.Collector]func(metricMap, prometheus.Collector) error{
+		gauge("overlay", "master_recovering", "1 if the overlay master module is recovering its state from the replicated log, 0 if not."): func(m metricMap, c prometheus.Collector) error {
+			return setGaugesFound(m, c.(*prometheus.GaugeVec), map[string][]string{
+				"overlay/master/recovering": {},
+			})
+		},
+		counter("overlay", "allocation_failures_total", "Total number of failed overlay allocations by type.", "type"): func(m metricMap, c prometheus.Collector) error {
+			return setCountersFound(m, c.(*settableCounterVec), map[string][]string{
+				"overlay/master/ip_allocation_failures":      {"ip"},
+				"overlay/master/ip6_allocation_failures":     {"ip6"},
+				"overlay/master/subnet_allocation_failures":  {"subnet"},
+				"overlay/master/subnet6_allocation_failures": {"subnet6"},
+				"overlay/master/bridge_allocation_failures":  {"bridge"},
+			})
+		},
+	}
+	return newMetricCollector(httpClient, metrics)
+}
+
+// Metrics the overlay module publishes in the agent's /metrics/snapshot.
+func newOverlayAgentMetricCollector(httpClient *httpClient) prometheus.Collector {
+	metrics := map[prometheus.Collector]func(metricMap, prometheus.Collector) error{
+		counter("overlay", "agent_failures_total", "Total number of failed overlay configurations on the agent by step.", "step"): func(m metricMap, c prometheus.Collector) error {
+			return setCountersFound(m, c.(*settableCounterVec), map[string][]string{
+				"overlay/config_failed":        {"config"},
+				"overlay/docker_bridge_failed": {"docker_bridge"},
+				"overlay/mesos_bridge_failed":  {"mesos_bridge"},
+			})
+		},
+	}
+	return newMetricCollector(httpClient, metrics)
+}
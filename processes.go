@@ -0,0 +1,104 @@
+// Scrape the libprocess /__processes__ endpoint to find actors with a backed
+// up event queue. Information scraped at this point:
+//
+// * Number of running actors ("mesos_processes" series)
+// * Queued events by type for the top N actors ("mesos_process_event_queue_length" series)
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus.v2"
+)
+
+type (
+	processEvent struct {
+		Type string `json:"type"`
+	}
+
+	process struct {
+		ID     string         `json:"id"`
+		Events []processEvent `json:"events"`
+	}
+
+	processesCollector struct {
+		*httpClient
+		metrics map[prometheus.Collector]func([]process, prometheus.Collector)
+	}
+)
+
+// Event types reported per process, everything else is counted as "other".
+var processEventTypes = []string{"message", "http", "dispatch"}
+
+// Return the topN processes with the longest event queue, leaving out those
+// without queued events.
+func topProcesses(ps []process, topN int) []process {
+	sorted := []process{}
+	for _, p := range ps {
+		if len(p.Events) > 0 {
+			sorted = append(sorted, p)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].Events) > len(sorted[j].Events)
+	})
+	if len(sorted) > topN {
+		sorted = sorted[:topN]
+	}
+	return sorted
+}
+
+func newProcessesCollector(httpClient *httpClient, topN int) prometheus.Collector {
+	metrics := map[prometheus.Collector]func([]process, prometheus.Collector){
+		prometheus.NewGauge(prometheus.GaugeOpts{
+			Help:      "Current number of libprocess actors",
+			Namespace: "mesos",
+			Name:      "processes",
+		}): func(ps []process, c prometheus.Collector) {
+			c.(prometheus.Gauge).Set(float64(len(ps)))
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "Current number of queued events by type for the actors with the longest queues",
+			Namespace: "mesos",
+			Name:      "process_event_queue_length",
+		}, []string{"process", "type"}): func(ps []process, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, p := range topProcesses(ps, topN) {
+				lengths := map[string]float64{"other": 0}
+				for _, t := range processEventTypes {
+					lengths[t] = 0
+				}
+				for _, e := range p.Events {
+					t := strings.ToLower(e.Type)
+					if !inArray(t, processEventTypes) {
+						t = "other"
+					}
+					lengths[t]++
+				}
+				for t, l := range lengths {
+					c.(*prometheus.GaugeVec).WithLabelValues(p.ID, t).Set(l)
+				}
+			}
+		},
+	}
+
+	return &processesCollector{httpClient, metrics}
+}
+
+func (c *processesCollector) Collect(ch chan<- prometheus.Metric) {
+	var ps []process
+	if !c.fetchAndDecode("/__processes__", &ps) {
+		return
+	}
+	for c, set := range c.metrics {
+		set(ps, c)
+		c.Collect(ch)
+	}
+}
+
+func (c *processesCollector) Describe(ch chan<- *prometheus.Desc) {
+	for metric := range c.metrics {
+		metric.Describe(ch)
+	}
+}
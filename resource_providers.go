@@ -0,0 +1,203 @@
+// Query the agent v1 operator API (GET_RESOURCE_PROVIDERS), along with the
+// agent state, and /metrics/snapshot for the resource providers (Mesos 1.5+)
+// on the agent.
+// Information scraped at this point:
+//
+// * Subscribed resource providers ("mesos_slave_resource_provider_up" series)
+// * Capacity and usage of the resources they provide
+// * CSI plugin RPC and operation counters
+package main
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus.v2"
+)
+
+type (
+	resourceProvider struct {
+		Info struct {
+			ID struct {
+				Value string `json:"value"`
+			} `json:"id"`
+			Type string `json:"type"`
+			Name string `json:"name"`
+		} `json:"resource_provider_info"`
+		TotalResources []resourceFull `json:"total_resources"`
+	}
+
+	getResourceProvidersResponse struct {
+		GetResourceProviders struct {
+			ResourceProviders []resourceProvider `json:"resource_providers"`
+		} `json:"get_resource_providers"`
+	}
+)
+
+const resourceProviderPrefix = "resource_providers/"
+
+// Split a resource provider snapshot key such as
+// "resource_providers/org.apache.mesos.rp.local.storage.lvm/csi_plugin/rpcs_failed"
+// into the provider ("<type>.<name>") and the rest of the key.
+func splitResourceProviderKey(key string) (provider string, rest string, ok bool) {
+	if !strings.HasPrefix(key, resourceProviderPrefix) {
+		return "", "", false
+	}
+	parts := strings.SplitN(key[len(resourceProviderPrefix):], "/", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// Return the scalar resources of the given provider, summed by name.
+func providerResources(rs []resourceFull, providerID string) map[string]float64 {
+	amounts := map[string]float64{}
+	for _, r := range rs {
+		if r.Type != "SCALAR" || r.ProviderID == nil || r.ProviderID.Value != providerID {
+			continue
+		}
+		amounts[r.Name] += r.Scalar.Value
+	}
+	return amounts
+}
+
+// Metrics about the resource providers of the agent, set from the agent
+// state with the providers queried along with it.
+func resourceProviderMetrics() map[prometheus.Collector]func(*slaveState, prometheus.Collector) {
+	// ID of every provider seen, by type and name. A provider which is gone
+	// is reported as down until it comes back, possibly with a new ID.
+	known := map[[2]string]string{}
+
+	return map[prometheus.Collector]func(*slaveState, prometheus.Collector){
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "1 if the resource provider is subscribed to the agent, 0 if it was subscribed before but is gone",
+			Namespace: "mesos",
+			Subsystem: "slave",
+			Name:      "resource_provider_up",
+		}, []string{"id", "type", "name"}): func(st *slaveState, c prometheus.Collector) {
+			if st.providers == nil {
+				return
+			}
+			current := map[[2]string]string{}
+			for _, p := range st.providers.GetResourceProviders.ResourceProviders {
+				current[[2]string{p.Info.Type, p.Info.Name}] = p.Info.ID.Value
+			}
+
+			for k, id := range current {
+				if last, ok := known[k]; ok && last != id {
+					c.(*prometheus.GaugeVec).DeleteLabelValues(last, k[0], k[1])
+				}
+				known[k] = id
+				c.(*prometheus.GaugeVec).WithLabelValues(id, k[0], k[1]).Set(1)
+			}
+			for k, id := range known {
+				if _, ok := current[k]; !ok {
+					c.(*prometheus.GaugeVec).WithLabelValues(id, k[0], k[1]).Set(0)
+				}
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "Total and allocated resources of the resource provider (disk in MB)",
+			Namespace: "mesos",
+			Subsystem: "slave",
+			Name:      "resource_provider_resources",
+		}, []string{"id", "resource", "type"}): func(st *slaveState, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			if st.providers == nil {
+				return
+			}
+			allocated := append([]resourceFull{}, st.UnreservedResourcesAllocated...)
+			for _, rs := range st.ReservedResourcesAllocated {
+				allocated = append(allocated, rs...)
+			}
+
+			for _, p := range st.providers.GetResourceProviders.ResourceProviders {
+				id := p.Info.ID.Value
+				used := providerResources(allocated, id)
+				for name, total := range providerResources(p.TotalResources, id) {
+					c.(*prometheus.GaugeVec).WithLabelValues(id, name, "total").Set(total)
+					c.(*prometheus.GaugeVec).WithLabelValues(id, name, "used").Set(used[name])
+				}
+			}
+		},
+	}
+}
+
+// CSI plugin and operation metrics published per resource provider in
+// /metrics/snapshot. Both the Mesos 1.5 (csi_plugin/rpcs_<outcome>) and
+// 1.7+ (csi_plugin/rpcs/<rpc>/<outcome>) formats are understood.
+func newResourceProviderMetricCollector(httpClient *httpClient) prometheus.Collector {
+	metrics := map[prometheus.Collector]func(metricMap, prometheus.Collector) error{
+		counter("slave", "csi_plugin_rpcs_total", "Total number of completed CSI plugin calls by resource provider, call and outcome.", "provider", "rpc", "outcome"): func(m metricMap, c prometheus.Collector) error {
+			for k, v := range m {
+				provider, rest, ok := splitResourceProviderKey(k)
+				if !ok || !strings.HasPrefix(rest, "csi_plugin/rpcs") {
+					continue
+				}
+				rest = strings.TrimPrefix(rest, "csi_plugin/rpcs")
+				var rpc, outcome string
+				if strings.HasPrefix(rest, "_") {
+					outcome = rest[1:]
+				} else if i := strings.LastIndex(rest, "/"); i > 0 {
+					rpc, outcome = rest[1:i], rest[i+1:]
+				}
+				if outcome == "" || outcome == "pending" {
+					continue
+				}
+				c.(*settableCounterVec).Set(v, provider, rpc, outcome)
+			}
+			return nil
+		},
+		gauge("slave", "csi_plugin_rpcs_pending", "Current number of pending CSI plugin calls by resource provider and call.", "provider", "rpc"): func(m metricMap, c prometheus.Collector) error {
+			c.(*prometheus.GaugeVec).Reset()
+			for k, v := range m {
+				provider, rest, ok := splitResourceProviderKey(k)
+				if !ok {
+					continue
+				}
+				if rest == "csi_plugin/rpcs_pending" {
+					c.(*prometheus.GaugeVec).WithLabelValues(provider, "").Set(v)
+				} else if rpc, ok := keyInfix(rest, "csi_plugin/rpcs/", "/pending"); ok {
+					c.(*prometheus.GaugeVec).WithLabelValues(provider, rpc).Set(v)
+				}
+			}
+			return nil
+		},
+		counter("slave", "csi_plugin_container_terminations_total", "Total number of CSI plugin container terminations by resource provider.", "provider"): func(m metricMap, c prometheus.Collector) error {
+			for k, v := range m {
+				if provider, rest, ok := splitResourceProviderKey(k); ok && rest == "csi_plugin/container_terminations" {
+					c.(*settableCounterVec).Set(v, provider)
+				}
+			}
+			return nil
+		},
+		counter("slave", "resource_provider_operations_total", "Total number of completed resource provider operations by type and outcome.", "provider", "operation", "outcome"): func(m metricMap, c prometheus.Collector) error {
+			for k, v := range m {
+				provider, rest, ok := splitResourceProviderKey(k)
+				if !ok || !strings.HasPrefix(rest, "operations/") {
+					continue
+				}
+				parts := strings.Split(rest, "/")
+				if len(parts) != 3 || parts[2] == "pending" {
+					continue
+				}
+				c.(*settableCounterVec).Set(v, provider, parts[1], parts[2])
+			}
+			return nil
+		},
+		gauge("slave", "resource_provider_operations_pending", "Current number of pending resource provider operations by type.", "provider", "operation"): func(m metricMap, c prometheus.Collector) error {
+			c.(*prometheus.GaugeVec).Reset()
+			for k, v := range m {
+				provider, rest, ok := splitResourceProviderKey(k)
+				if !ok {
+					continue
+				}
+				if operation, ok := keyInfix(rest, "operations/", "/pending"); ok {
+					c.(*prometheus.GaugeVec).WithLabelValues(provider, operation).Set(v)
+				}
+			}
+			return nil
+		},
+	}
+	return newMetricCollector(httpClient, metrics)
+}
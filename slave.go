@@ -193,6 +193,75 @@ func newSlaveCollector(httpClient *httpClient) prometheus.Collector {
 
 			return nil
 		},
+
+		// Slave stats about containerizer and fetcher
+		counter("slave", "container_errors_total", "Total number of container errors by reason.", "reason"): func(m metricMap, c prometheus.Collector) error {
+			return setCountersFound(m, c.(*settableCounterVec), map[string][]string{
+				"slave/container_launch_errors":                {"launch"},
+				"containerizer/mesos/container_destroy_errors": {"destroy"},
+			})
+		},
+		gauge("slave", "fetcher_cache_bytes", "Current fetcher cache size in bytes.", "type"): func(m metricMap, c prometheus.Collector) error {
+			total, ok := m["containerizer/fetcher/cache_size_total_bytes"]
+			used, ok2 := m["containerizer/fetcher/cache_size_used_bytes"]
+			if !ok || !ok2 {
+				return notPublishedInMap
+			}
+			c.(*prometheus.GaugeVec).WithLabelValues("free").Set(total - used)
+			c.(*prometheus.GaugeVec).WithLabelValues("used").Set(used)
+			return nil
+		},
+		counter("slave", "fetcher_task_fetches_total", "Total number of fetcher runs by reason.", "reason"): func(m metricMap, c prometheus.Collector) error {
+			return setCountersFound(m, c.(*settableCounterVec), map[string][]string{
+				"containerizer/fetcher/task_fetches_succeeded": {"succeeded"},
+				"containerizer/fetcher/task_fetches_failed":    {"failed"},
+			})
+		},
+
+		// Slave stats about garbage collection
+		counter("slave", "gc_path_removals_total", "Total number of sandbox path removals by reason.", "reason"): func(m metricMap, c prometheus.Collector) error {
+			return setCountersFound(m, c.(*settableCounterVec), map[string][]string{
+				"gc/path_removals_succeeded": {"succeeded"},
+				"gc/path_removals_failed":    {"failed"},
+			})
+		},
+		gauge("slave", "gc_path_removals_pending", "Current number of sandbox paths scheduled for removal."): func(m metricMap, c prometheus.Collector) error {
+			return setGaugesFound(m, c.(*prometheus.GaugeVec), map[string][]string{
+				"gc/path_removals_pending": {},
+			})
+		},
+		gauge("slave", "executor_directory_max_allowed_age_seconds", "Maximum age of an executor directory before it is garbage collected."): func(m metricMap, c prometheus.Collector) error {
+			return setGaugesFound(m, c.(*prometheus.GaugeVec), map[string][]string{
+				"slave/executor_directory_max_allowed_age_secs": {},
+			})
+		},
+
+		// Slave stats about recovery
+		counter("slave", "recovery_errors_total", "Total number of errors encountered during agent recovery."): func(m metricMap, c prometheus.Collector) error {
+			return setCountersFound(m, c.(*settableCounterVec), map[string][]string{
+				"slave/recovery_errors": {},
+			})
+		},
+		gauge("slave", "recovery_seconds", "Time the agent took to recover executors after restart."): func(m metricMap, c prometheus.Collector) error {
+			return setGaugesFound(m, c.(*prometheus.GaugeVec), map[string][]string{
+				"slave/recovery_time_secs": {},
+			})
+		},
+
+		// Slave stats about volume gid allocation
+		gauge("slave", "volume_gids", "Current number of volume gids by state.", "type"): func(m metricMap, c prometheus.Collector) error {
+			total, ok := m["volume_gid_manager/volume_gids_total"]
+			free, ok2 := m["volume_gid_manager/volume_gids_free"]
+			if !ok || !ok2 {
+				return notPublishedInMap
+			}
+			c.(*prometheus.GaugeVec).WithLabelValues("free").Set(free)
+			c.(*prometheus.GaugeVec).WithLabelValues("used").Set(total - free)
+			return nil
+		},
+	}
+	for c, f := range systemMetrics("slave") {
+		metrics[c] = f
 	}
 	return newMetricCollector(httpClient, metrics)
 }
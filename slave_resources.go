@@ -0,0 +1,166 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus.v2"
+)
+
+type (
+	// Resource in the protobuf based format of the *_resources_full fields.
+	resourceFull struct {
+		Name   string `json:"name"`
+		Type   string `json:"type"`
+		Scalar struct {
+			Value float64 `json:"value"`
+		} `json:"scalar"`
+		// Pre Mesos 1.4 reservation format
+		Role        string       `json:"role"`
+		Reservation *reservation `json:"reservation"`
+		// Mesos 1.4+ (hierarchical) reservation format
+		Reservations []reservation `json:"reservations"`
+		Disk         *diskInfo     `json:"disk"`
+		ProviderID   *struct {
+			Value string `json:"value"`
+		} `json:"provider_id"`
+	}
+
+	reservation struct {
+		Type      string `json:"type"`
+		Role      string `json:"role"`
+		Principal string `json:"principal"`
+	}
+
+	diskInfo struct {
+		Persistence *struct {
+			ID        string `json:"id"`
+			Principal string `json:"principal"`
+		} `json:"persistence"`
+		Source *struct {
+			Type string `json:"type"`
+		} `json:"source"`
+	}
+)
+
+// Return whether the resource is reserved for a role.
+func (r *resourceFull) reserved() bool {
+	return len(r.Reservations) > 0 || r.Reservation != nil || (r.Role != "" && r.Role != "*")
+}
+
+// Return whether the resource is reserved dynamically or statically.
+func (r *resourceFull) reservationType() string {
+	if len(r.Reservations) > 0 {
+		return strings.ToLower(r.Reservations[len(r.Reservations)-1].Type)
+	}
+	if r.Reservation != nil {
+		return "dynamic"
+	}
+	return "static"
+}
+
+// Return the disk source type (MOUNT, PATH, CSI, ...), "root" for disk
+// resources carved from the agent's work directory.
+func (r *resourceFull) diskSource() string {
+	if r.Disk == nil || r.Disk.Source == nil {
+		return "root"
+	}
+	return strings.ToLower(r.Disk.Source.Type)
+}
+
+func (r *resourceFull) persistenceID() string {
+	if r.Disk == nil || r.Disk.Persistence == nil {
+		return ""
+	}
+	return r.Disk.Persistence.ID
+}
+
+// Metrics about the resources reserved on the slave and the persistent
+// volumes created from them.
+func slaveResourceMetrics() map[prometheus.Collector]func(*slaveState, prometheus.Collector) {
+	volumeLabels := []string{"id", "role", "principal", "source"}
+
+	// Call f for every persistent volume on the slave.
+	eachVolume := func(st *slaveState, f func(role string, r *resourceFull)) {
+		for role, rs := range st.ReservedResourcesFull {
+			for i := range rs {
+				if rs[i].persistenceID() != "" {
+					f(role, &rs[i])
+				}
+			}
+		}
+	}
+
+	return map[prometheus.Collector]func(*slaveState, prometheus.Collector){
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "Reserved slave resources by role and reservation type (cpus fractional, mem and disk in MB)",
+			Namespace: "mesos",
+			Subsystem: "slave",
+			Name:      "reserved_resources",
+		}, []string{"role", "resource", "type"}): func(st *slaveState, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for role, rs := range st.ReservedResourcesFull {
+				for _, r := range rs {
+					if r.Type != "SCALAR" {
+						continue
+					}
+					c.(*prometheus.GaugeVec).WithLabelValues(role, r.Name, r.reservationType()).Add(r.Scalar.Value)
+				}
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "Slave disk resources in bytes by role and disk source",
+			Namespace: "mesos",
+			Subsystem: "slave",
+			Name:      "disk_source_bytes",
+		}, []string{"role", "source"}): func(st *slaveState, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for role, rs := range st.ReservedResourcesFull {
+				for _, r := range rs {
+					if r.Name == "disk" {
+						c.(*prometheus.GaugeVec).WithLabelValues(role, r.diskSource()).Add(r.Scalar.Value * 1024 * 1024)
+					}
+				}
+			}
+			for _, r := range st.UnreservedResourcesFull {
+				if r.Name == "disk" {
+					c.(*prometheus.GaugeVec).WithLabelValues("*", r.diskSource()).Add(r.Scalar.Value * 1024 * 1024)
+				}
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "Size of persistent volumes in bytes",
+			Namespace: "mesos",
+			Subsystem: "slave",
+			Name:      "persistent_volume_bytes",
+		}, volumeLabels): func(st *slaveState, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			eachVolume(st, func(role string, r *resourceFull) {
+				c.(*prometheus.GaugeVec).WithLabelValues(r.persistenceID(), role, r.Disk.Persistence.Principal, r.diskSource()).Set(r.Scalar.Value * 1024 * 1024)
+			})
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "1 if the persistent volume is allocated to a task or executor, 0 if not",
+			Namespace: "mesos",
+			Subsystem: "slave",
+			Name:      "persistent_volume_in_use",
+		}, volumeLabels): func(st *slaveState, c prometheus.Collector) {
+			inUse := map[string]bool{}
+			for _, rs := range st.ReservedResourcesAllocated {
+				for _, r := range rs {
+					if id := r.persistenceID(); id != "" {
+						inUse[id] = true
+					}
+				}
+			}
+
+			c.(*prometheus.GaugeVec).Reset()
+			eachVolume(st, func(role string, r *resourceFull) {
+				value := 0.0
+				if inUse[r.persistenceID()] {
+					value = 1
+				}
+				c.(*prometheus.GaugeVec).WithLabelValues(r.persistenceID(), role, r.Disk.Persistence.Principal, r.diskSource()).Set(value)
+			})
+		},
+	}
+}
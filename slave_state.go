@@ -2,10 +2,14 @@
 // on executors. Information scraped at this point:
 //
 // * Labels of running tasks ("mesos_slave_task_labels" series)
+// * Reserved resources by role and reservation type
+// * Persistent volumes and disk sources
+// * Resource providers, queried from the v1 operator API along with the state
 package main
 
 import (
 	"regexp"
+	"sync"
 
 	"github.com/prometheus/client_golang/prometheus.v2"
 )
@@ -17,11 +21,21 @@ type (
 	}
 
 	slaveState struct {
-		Frameworks []slaveFramework `json:"frameworks"`
+		Frameworks                   []slaveFramework          `json:"frameworks"`
+		ReservedResourcesFull        map[string][]resourceFull `json:"reserved_resources_full"`
+		UnreservedResourcesFull      []resourceFull            `json:"unreserved_resources_full"`
+		ReservedResourcesAllocated   map[string][]resourceFull `json:"reserved_resources_allocated"`
+		UnreservedResourcesAllocated []resourceFull            `json:"unreserved_resources_allocated"`
+
+		// Resource providers of the agent, nil if they weren't queried
+		providers *getResourceProvidersResponse
 	}
 
 	slaveStateCollector struct {
 		*httpClient
+		providers bool
+		// Serialises scrapes, resource_provider_up keeps state across them
+		sync.Mutex
 		metrics map[prometheus.Collector]func(*slaveState, prometheus.Collector)
 	}
 )
@@ -47,7 +61,7 @@ func inArray(needle string, haystack []string) bool {
 	return false
 }
 
-func newSlaveStateCollector(httpClient *httpClient, userTaskLabelList []string) *slaveStateCollector {
+func newSlaveStateCollector(httpClient *httpClient, userTaskLabelList []string, resources bool, providers bool) *slaveStateCollector {
 	defaultLabels := []string{"source", "framework_id", "executor_id"}
 
 	// Sanitise user-supplied list of task labels that should be included in the series
@@ -91,13 +105,37 @@ func newSlaveStateCollector(httpClient *httpClient, userTaskLabelList []string)
 			}
 		},
 	}
+	// Task labels are only exported if the user whitelisted some
+	if len(userTaskLabelList) == 0 {
+		metrics = map[prometheus.Collector]func(*slaveState, prometheus.Collector){}
+	}
+	if resources {
+		for c, f := range slaveResourceMetrics() {
+			metrics[c] = f
+		}
+	}
+
+	if providers {
+		for c, f := range resourceProviderMetrics() {
+			metrics[c] = f
+		}
+	}
 
-	return &slaveStateCollector{httpClient, metrics}
+	return &slaveStateCollector{httpClient: httpClient, providers: providers, metrics: metrics}
 }
 
 func (c *slaveStateCollector) Collect(ch chan<- prometheus.Metric) {
+	c.Lock()
+	defer c.Unlock()
+
 	var s slaveState
 	c.fetchAndDecode("/slave(1)/state", &s)
+	if c.providers {
+		var res getResourceProvidersResponse
+		if c.postAndDecode("/api/v1", map[string]string{"type": "GET_RESOURCE_PROVIDERS"}, &res) {
+			s.providers = &res
+		}
+	}
 	for c, set := range c.metrics {
 		set(&s, c)
 		c.Collect(ch)
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus.v2"
+)
+
+// Return the health reported by the latest status update carrying a health
+// check result. ok is false if the task has no health check.
+func (t *task) health() (healthy bool, ok bool) {
+	for i := len(t.Statuses) - 1; i >= 0; i-- {
+		if h := t.Statuses[i].Healthy; h != nil {
+			return *h, true
+		}
+	}
+	return false, false
+}
+
+type taskHealthChange struct {
+	framework *framework
+	task      *task
+	healthy   bool
+}
+
+// Return the running tasks whose health changed since the previous scrape,
+// given their health in the previous scrape by task ID, along with their
+// current health. The master only keeps the latest of consecutive status
+// updates in the same task state, so health flips can't be taken from the
+// status history. A task first seen unhealthy counts as a change.
+func healthChanges(st *state, last map[string]bool) ([]taskHealthChange, map[string]bool) {
+	changes := []taskHealthChange{}
+	current := map[string]bool{}
+	for i := range st.Frameworks {
+		f := &st.Frameworks[i]
+		for j := range f.Tasks {
+			t := &f.Tasks[j]
+			healthy, ok := t.health()
+			if !ok || t.State != "TASK_RUNNING" {
+				continue
+			}
+			current[t.ID] = healthy
+			previous, seen := last[t.ID]
+			if !seen {
+				previous = true
+			}
+			if healthy != previous {
+				changes = append(changes, taskHealthChange{f, t, healthy})
+			}
+		}
+	}
+	return changes, current
+}
+
+// Return whether the latest general check (Mesos 1.2+ check_status) of the
+// task succeeded. ok is false if the task has no check or it hasn't run yet.
+func (t *task) checkSucceeded() (succeeded bool, ok bool) {
+	for i := len(t.Statuses) - 1; i >= 0; i-- {
+		cs := t.Statuses[i].CheckStatus
+		if cs == nil {
+			continue
+		}
+		switch {
+		case cs.Command != nil && cs.Command.ExitCode != nil:
+			return *cs.Command.ExitCode == 0, true
+		case cs.HTTP != nil && cs.HTTP.StatusCode != nil:
+			return *cs.HTTP.StatusCode >= 200 && *cs.HTTP.StatusCode < 400, true
+		case cs.TCP != nil && cs.TCP.Succeeded != nil:
+			return *cs.TCP.Succeeded, true
+		}
+		return false, false
+	}
+	return false, false
+}
+
+func taskHealthMetrics() map[prometheus.Collector]func(*state, prometheus.Collector) {
+	// Health of the running tasks in the previous scrape by task ID
+	lastHealth := map[string]bool{}
+
+	return map[prometheus.Collector]func(*state, prometheus.Collector){
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "1 if the running task passes its health check, 0 if not",
+			Namespace: "mesos",
+			Subsystem: "task",
+			Name:      "healthy",
+		}, []string{"framework_id", "task", "name"}): func(st *state, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, f := range st.Frameworks {
+				for _, t := range f.Tasks {
+					healthy, ok := t.health()
+					if !ok || t.State != "TASK_RUNNING" {
+						continue
+					}
+					value := 0.0
+					if healthy {
+						value = 1
+					}
+					c.(*prometheus.GaugeVec).WithLabelValues(f.ID, t.ID, t.Name).Set(value)
+				}
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "1 if the latest check of the running task succeeded, 0 if not",
+			Namespace: "mesos",
+			Subsystem: "task",
+			Name:      "check_succeeded",
+		}, []string{"framework_id", "task", "name"}): func(st *state, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, f := range st.Frameworks {
+				for _, t := range f.Tasks {
+					succeeded, ok := t.checkSucceeded()
+					if !ok || t.State != "TASK_RUNNING" {
+						continue
+					}
+					value := 0.0
+					if succeeded {
+						value = 1
+					}
+					c.(*prometheus.GaugeVec).WithLabelValues(f.ID, t.ID, t.Name).Set(value)
+				}
+			}
+		},
+		prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Help:      "Current number of running tasks with a health check by framework, task name and health",
+			Namespace: "mesos",
+			Subsystem: "framework",
+			Name:      "tasks_health",
+		}, []string{"framework_id", "framework", "name", "health"}): func(st *state, c prometheus.Collector) {
+			c.(*prometheus.GaugeVec).Reset()
+			for _, f := range st.Frameworks {
+				for _, t := range f.Tasks {
+					healthy, ok := t.health()
+					if !ok || t.State != "TASK_RUNNING" {
+						continue
+					}
+					c.(*prometheus.GaugeVec).WithLabelValues(f.ID, f.Name, t.Name, "healthy").Add(0)
+					c.(*prometheus.GaugeVec).WithLabelValues(f.ID, f.Name, t.Name, "unhealthy").Add(0)
+					if healthy {
+						c.(*prometheus.GaugeVec).WithLabelValues(f.ID, f.Name, t.Name, "healthy").Inc()
+					} else {
+						c.(*prometheus.GaugeVec).WithLabelValues(f.ID, f.Name, t.Name, "unhealthy").Inc()
+					}
+				}
+			}
+		},
+		prometheus.NewCounterVec(prometheus.CounterOpts{
+			Help:      "Total number of task health transitions by framework, task name and new health",
+			Namespace: "mesos",
+			Subsystem: "framework",
+			Name:      "task_health_transitions_total",
+		}, []string{"framework_id", "framework", "name", "health"}): func(st *state, c prometheus.Collector) {
+			if !st.fetched {
+				return
+			}
+			changes, current := healthChanges(st, lastHealth)
+			for _, ch := range changes {
+				health := "unhealthy"
+				if ch.healthy {
+					health = "healthy"
+				}
+				c.(*prometheus.CounterVec).WithLabelValues(ch.framework.ID, ch.framework.Name, ch.task.Name, health).Inc()
+			}
+			lastHealth = current
+		},
+	}
+}